@@ -3,6 +3,7 @@ module chia_monitor
 go 1.16
 
 require (
+	github.com/gorilla/websocket v1.5.0
 	github.com/jonboulle/clockwork v0.2.2 // indirect
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
 	github.com/lestrrat-go/strftime v1.0.5 // indirect
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/chia"
+	"chia_monitor/src/config"
+	"chia_monitor/src/mojo"
+	"chia_monitor/src/monitor"
+)
+
+// Clients 根据配置创建农民及钱包的rpc对象
+type Clients func(cfg *config.Config) (chia.Farmer, chia.Wallet)
+
+// Server 状态接口，以JSON提供各监控的状态及收割机、矿池、钱包信息
+type Server struct {
+	registry *monitor.Registry
+	clients  Clients
+	server   *http.Server
+}
+
+// MonitorStatus 单个监控的当前状态
+type MonitorStatus struct {
+	Monitor   string             `json:"monitor"`
+	Enabled   bool               `json:"enabled"`
+	Interval  string             `json:"interval,omitempty"` //按间隔调度时的间隔
+	Cron      string             `json:"cron,omitempty"`     //按cron调度时的表达式
+	Checked   bool               `json:"checked"`            //启动后是否已检查过
+	Status    monitor.Status     `json:"status"`
+	Summary   string             `json:"summary,omitempty"`
+	Detail    string             `json:"detail,omitempty"`
+	LastCheck *time.Time         `json:"last_check,omitempty"`
+	LastError *monitor.LastError `json:"last_error,omitempty"`
+	Metrics   map[string]float64 `json:"metrics,omitempty"`
+}
+
+// StatusResponse /api/status返回
+type StatusResponse struct {
+	Status        monitor.Status     `json:"status"` //已检查监控中最严重的状态
+	Monitors      []MonitorStatus    `json:"monitors"`
+	OpenIncidents []monitor.Incident `json:"open_incidents"`
+	Incidents     []monitor.Incident `json:"incidents"` //最近的故障，按开始时间倒序
+}
+
+// WalletBalance /api/wallet返回的单个钱包余额，单位：mojo
+type WalletBalance struct {
+	Id          int         `json:"id"`
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Confirmed   mojo.Amount `json:"confirmed"`
+	Spendable   mojo.Amount `json:"spendable"`
+	Unconfirmed mojo.Amount `json:"unconfirmed"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// NewServer 创建状态接口，每次请求按当前配置创建rpc对象，重新加载配置后立即使用新的rpc地址及证书
+func NewServer(addr string, registry *monitor.Registry, clients Clients) *Server {
+	s := &Server{registry: registry, clients: clients}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/api/status", s.auth(s.status))
+	mux.HandleFunc("/api/harvesters", s.auth(s.harvesters))
+	mux.HandleFunc("/api/pool", s.auth(s.pool))
+	mux.HandleFunc("/api/wallet", s.auth(s.walletBalances))
+	s.server = &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: time.Minute,
+	}
+	return s
+}
+
+// Start 监听地址并在后台提供服务，监听失败时返回错误
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Status api server err: %s", err)
+		}
+	}()
+	log.Infof("Status api listen on %s", s.server.Addr)
+	return nil
+}
+
+// Shutdown 停止接收新请求，等待正在处理的请求结束
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// auth 配置了apiToken时校验Bearer Token，每次请求读取配置，重新加载配置后立即生效
+func (s *Server) auth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := config.GetConfig().ApiToken
+		if token != "" {
+			//必须带Bearer前缀，不接受直接传token
+			header := r.Header.Get("Authorization")
+			given := strings.TrimPrefix(header, "Bearer ")
+			if !strings.HasPrefix(header, "Bearer ") || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// healthz 进程存活检查，不校验Token
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// status 各监控最近一次的检查结果及故障记录
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	store := s.registry.Store()
+	response := StatusResponse{
+		Monitors:      []MonitorStatus{},
+		OpenIncidents: []monitor.Incident{},
+		Incidents:     store.Incidents(),
+	}
+	var checked []monitor.Result
+	for _, m := range s.registry.Monitors() {
+		name := m.Name()
+		schedule := s.registry.Schedule(name)
+		status := MonitorStatus{
+			Monitor: name,
+			Enabled: s.registry.Enabled(name),
+			Cron:    schedule.Cron,
+			Status:  monitor.StatusUnknown,
+		}
+		if schedule.Cron == "" {
+			status.Interval = schedule.Interval.String()
+		}
+		if result, ok := store.Last(name); ok {
+			checked = append(checked, result)
+			status.Checked = true
+			status.Status = result.Status
+			status.Summary = result.Summary
+			status.Detail = result.Detail
+			status.LastCheck = &result.Time
+			status.Metrics = result.Metrics
+		}
+		if lastError, ok := store.LastError(name); ok {
+			status.LastError = &lastError
+		}
+		response.Monitors = append(response.Monitors, status)
+	}
+	response.Status = monitor.Overall(checked)
+	for _, incident := range response.Incidents {
+		if incident.IsOpen() {
+			response.OpenIncidents = append(response.OpenIncidents, incident)
+		}
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+// farmer 按当前配置创建农民rpc对象，客户端断开时中断请求
+func (s *Server) farmer(r *http.Request) chia.Farmer {
+	farmer, _ := s.clients(config.GetConfig())
+	return farmer.WithContext(r.Context())
+}
+
+// wallet 按当前配置创建钱包rpc对象，客户端断开时中断请求
+func (s *Server) wallet(r *http.Request) chia.Wallet {
+	_, wallet := s.clients(config.GetConfig())
+	return wallet.WithContext(r.Context())
+}
+
+// harvesters 已连接的收割机及配置中掉线的收割机
+func (s *Server) harvesters(w http.ResponseWriter, r *http.Request) {
+	harvesters, offline, err := s.farmer(r).Harvesters()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if harvesters == nil {
+		harvesters = []chia.HarvesterInfo{}
+	}
+	if offline == nil {
+		offline = []string{}
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"harvesters": harvesters,
+		"offline":    offline,
+	})
+}
+
+// pool 矿池状态及最近一次的收益统计
+func (s *Server) pool(w http.ResponseWriter, r *http.Request) {
+	cfg := config.GetConfig()
+	if !cfg.Monitor.IsSupportPool {
+		writeError(w, http.StatusNotFound, "pool is not enabled")
+		return
+	}
+	poolStateRpcResult, err := s.farmer(r).GetPoolState()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if !poolStateRpcResult.Success {
+		writeError(w, http.StatusBadGateway, poolStateRpcResult.Error)
+		return
+	}
+	response := map[string]interface{}{
+		"pool_name":   cfg.Monitor.PoolName,
+		"launcher_id": cfg.Monitor.LauncherId,
+		"pool_state":  poolStateRpcResult.PoolState,
+	}
+	if earning, ok := s.registry.Store().Last("poolEarning"); ok {
+		response["earning"] = earning
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+// walletBalances 所有钱包的余额
+func (s *Server) walletBalances(w http.ResponseWriter, r *http.Request) {
+	walletSummaries, err := s.wallet(r).GetAllWalletBalances()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	balances := make([]WalletBalance, 0, len(walletSummaries))
+	for _, walletSummary := range walletSummaries {
+		balances = append(balances, WalletBalance{
+			Id:          walletSummary.Id,
+			Name:        walletSummary.Name,
+			Type:        walletSummary.TypeName(),
+			Confirmed:   walletSummary.WalletBalance.ConfirmedWalletBalance,
+			Spendable:   walletSummary.WalletBalance.SpendableBalance,
+			Unconfirmed: walletSummary.WalletBalance.UnconfirmedWalletBalance,
+			Error:       walletSummary.Error,
+		})
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{"wallets": balances})
+}
+
+// writeJson 输出JSON
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Write status api response err: %s", err)
+	}
+}
+
+// writeError 输出错误
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJson(w, code, map[string]string{"error": message})
+}
@@ -0,0 +1,153 @@
+package bech32
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32mConst bech32m校验和常量（BIP-350）
+const bech32mConst = 0x2bc830a3
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// polymod 计算校验和
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, value := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(value)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// hrpExpand 展开hrp用于计算校验和
+func hrpExpand(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+	return result
+}
+
+// Encode 将8位数据编码为bech32m字符串，如：puzzle hash编码为xch1...地址
+func Encode(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	return encodeValues(hrp, values), nil
+}
+
+// encodeValues 将5位数据加上bech32m校验和编码为字符串
+func encodeValues(hrp string, values []byte) string {
+	checksumValues := append(hrpExpand(hrp), values...)
+	mod := polymod(append(checksumValues, 0, 0, 0, 0, 0, 0)) ^ bech32mConst
+	var builder strings.Builder
+	builder.WriteString(hrp)
+	builder.WriteByte('1')
+	for _, value := range values {
+		builder.WriteByte(charset[value])
+	}
+	for i := 0; i < 6; i++ {
+		builder.WriteByte(charset[mod>>uint(5*(5-i))&31])
+	}
+	return builder.String()
+}
+
+// Decode 解码bech32m字符串，返回hrp和8位数据
+func Decode(address string) (hrp string, data []byte, err error) {
+	hrp, values, err := decodeValues(address)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err = convertBits(values, 5, 8, false)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "invalid data of address %q", address)
+	}
+	return hrp, data, nil
+}
+
+// decodeValues 校验bech32m字符串，返回hrp和去掉校验和的5位数据
+func decodeValues(address string) (hrp string, values []byte, err error) {
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return "", nil, errors.Errorf("mixed case in address %q", address)
+	}
+	address = strings.ToLower(address)
+	pos := strings.LastIndexByte(address, '1')
+	if pos < 1 || pos+7 > len(address) || len(address) > 90 {
+		return "", nil, errors.Errorf("invalid address %q", address)
+	}
+	hrp = address[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, errors.Errorf("invalid character in hrp of address %q", address)
+		}
+	}
+
+	values = make([]byte, 0, len(address)-pos-1)
+	for _, c := range address[pos+1:] {
+		index := strings.IndexRune(charset, c)
+		if index < 0 {
+			return "", nil, errors.Errorf("invalid character %q in address %q", c, address)
+		}
+		values = append(values, byte(index))
+	}
+	if polymod(append(hrpExpand(hrp), values...)) != bech32mConst {
+		return "", nil, errors.Errorf("invalid bech32m checksum of address %q", address)
+	}
+	return hrp, values[:len(values)-6], nil
+}
+
+// DecodePuzzleHash 将xch1...地址解码为32字节的puzzle hash，地址前缀必须为prefix，如：主网xch、测试网txch
+func DecodePuzzleHash(address, prefix string) (puzzleHash []byte, err error) {
+	hrp, puzzleHash, err := Decode(address)
+	if err != nil {
+		return nil, err
+	}
+	if hrp != prefix {
+		return nil, errors.Errorf("address %q is not a %s address", address, prefix)
+	}
+	if len(puzzleHash) != 32 {
+		return nil, errors.Errorf("invalid puzzle hash length %d of address %q", len(puzzleHash), address)
+	}
+	return puzzleHash, nil
+}
+
+// convertBits 在不同位宽之间转换数据
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxValue := uint32(1)<<toBits - 1
+	var result []byte
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			return nil, errors.Errorf("invalid data value %d", value)
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxValue))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxValue != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return result, nil
+}
@@ -0,0 +1,142 @@
+package bech32
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	//BIP-350中有效的bech32m测试向量
+	valid := []struct {
+		in  string
+		hrp string
+	}{
+		{"A1LQFN3A", "a"},
+		{"a1lqfn3a", "a"},
+		{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", "abcdef"},
+		{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", "split"},
+		{"?1v759aa", "?"},
+	}
+	for _, tt := range valid {
+		hrp, _, err := Decode(tt.in)
+		if err != nil {
+			t.Errorf("Decode(%q) err = %v", tt.in, err)
+			continue
+		}
+		if hrp != tt.hrp {
+			t.Errorf("Decode(%q) hrp = %q, want %q", tt.in, hrp, tt.hrp)
+		}
+	}
+
+	//BIP-350中无效的bech32m测试向量，以及bech32（非m）编码的地址
+	invalid := []string{
+		"qyrz8wqd2c9m",  //没有分隔符
+		"1qyrz8wqd2c9m", //hrp为空
+		"y1b0jsk6g",     //数据中有无效字符
+		"lt1igcx5c0",    //数据中有无效字符
+		"in1muywd",      //校验和太短
+		"mm1crxm3i",     //校验和中有无效字符
+		"au1s5cgom",     //校验和中有无效字符
+		"M1VUXWEZ",      //按大写hrp计算的校验和
+		"16plkw9",       //hrp为空
+		"1p2gdwpf",      //hrp为空
+		"A1lqfn3a",      //大小写混合
+		"a12uel5l",      //bech32校验和
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",                                               //bech32校验和
+		"an84characterslonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11d6pts4", //超过90个字符
+	}
+	for _, in := range invalid {
+		if _, _, err := Decode(in); err == nil {
+			t.Errorf("Decode(%q) err = nil, want error", in)
+		}
+	}
+}
+
+// segwitVectors BIP-350中有效的bech32m隔离见证地址及对应的见证版本和见证程序
+var segwitVectors = []struct {
+	address string
+	hrp     string
+	version byte
+	program string
+}{
+	{"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", "bc", 1, "751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+	{"BC1SW50QGDZ25J", "bc", 16, "751e"},
+	{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", "bc", 2, "751e76e8199196d454941c45d1b3a323"},
+	{"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", "tb", 1, "000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+	{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "bc", 1, "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+}
+
+func TestSegwitVectors(t *testing.T) {
+	for _, tt := range segwitVectors {
+		hrp, values, err := decodeValues(tt.address)
+		if err != nil {
+			t.Errorf("decodeValues(%q) err = %v", tt.address, err)
+			continue
+		}
+		program, err := convertBits(values[1:], 5, 8, false)
+		if err != nil {
+			t.Errorf("convertBits of %q err = %v", tt.address, err)
+			continue
+		}
+		if hrp != tt.hrp || values[0] != tt.version || hex.EncodeToString(program) != tt.program {
+			t.Errorf("decode %q = %s, %d, %x, want %s, %d, %s", tt.address, hrp, values[0], program, tt.hrp, tt.version, tt.program)
+		}
+
+		//按见证版本和见证程序重新编码，与测试向量一致
+		expected, _ := hex.DecodeString(tt.program)
+		programValues, err := convertBits(expected, 8, 5, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := encodeValues(tt.hrp, append([]byte{tt.version}, programValues...)); got != strings.ToLower(tt.address) {
+			t.Errorf("encodeValues(%s, %d, %s) = %s, want %s", tt.hrp, tt.version, tt.program, got, strings.ToLower(tt.address))
+		}
+	}
+}
+
+func TestDecodePuzzleHash(t *testing.T) {
+	//BIP-350中32字节的见证程序作为puzzle hash
+	puzzleHash, _ := hex.DecodeString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	address, err := Encode("xch", puzzleHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testnetAddress, err := Encode("txch", puzzleHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortAddress, err := Encode("xch", puzzleHash[:20])
+	if err != nil {
+		t.Fatal(err)
+	}
+	//修改最后一个字符使校验和错误
+	last := address[len(address)-1]
+	badChecksum := address[:len(address)-1] + string(charset[(strings.IndexByte(charset, last)+1)%32])
+
+	tests := []struct {
+		address string
+		prefix  string
+		wantErr bool
+	}{
+		{address, "xch", false},
+		{strings.ToUpper(address), "xch", false},
+		{testnetAddress, "txch", false},
+		//前缀与网络不一致
+		{testnetAddress, "xch", true},
+		{address, "txch", true},
+		{badChecksum, "xch", true},
+		//数据不是32字节
+		{shortAddress, "xch", true},
+	}
+	for _, tt := range tests {
+		got, err := DecodePuzzleHash(tt.address, tt.prefix)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("DecodePuzzleHash(%q, %s) err = %v, wantErr %v", tt.address, tt.prefix, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && hex.EncodeToString(got) != hex.EncodeToString(puzzleHash) {
+			t.Errorf("DecodePuzzleHash(%q, %s) = %x, want %x", tt.address, tt.prefix, got, puzzleHash)
+		}
+	}
+}
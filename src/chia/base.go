@@ -1,20 +1,37 @@
 package chia
 
 import (
-	"os/exec"
+	"context"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"chia_monitor/src/mojo"
+	"chia_monitor/src/price"
+	"chia_monitor/src/remediation"
 )
 
-//重启chia
-func restartChia() {
-	cmd := exec.Command(restartChiaCmd)
-	//将root目录作为工作目录
-	cmd.Dir = "/root"
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Errorf("Execute restart chia cmd failed with error:\n%s", err.Error())
-	} else {
-		log.Infof("Execute restart chia cmd succedd with output:\n%s", string(output))
+//执行故障条件的下一个修复操作，返回修复结果描述及错误，修复操作成功执行时错误为nil
+func remediate(ctx context.Context, condition string) (string, error) {
+	result, err := remediation.Escalate(ctx, condition, nil)
+	if errors.Cause(err) == remediation.ErrNoAction {
+		return "未配置自动修复操作", err
+	}
+	return result, err
+}
+
+//未设置ctx时使用context.Background()
+func orBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+//法币价值后缀，未配置价格来源时为空，ctx取消时中断价格请求
+func fiatSuffix(ctx context.Context, amount mojo.Amount) string {
+	value := price.Format(orBackground(ctx), amount)
+	if value == "" {
+		return ""
 	}
+	return "（" + value + "）"
 }
@@ -1,25 +1,34 @@
 package chia
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"chia_monitor/src/config"
+	"chia_monitor/src/monitor"
+	"chia_monitor/src/remediation"
 	"chia_monitor/src/utils"
 	"chia_monitor/src/wechat"
 )
 
-const restartChiaCmd = "/root/restart.sh"
-const syncingCountMax = 6
-
 type BlockChain struct {
 	BaseUrl  string
 	CertPath string
 	KeyPath  string
 	WalletId int
+	ctx      context.Context //rpc请求使用的ctx，为空时不可取消
+}
+
+// WithContext 返回使用ctx发起rpc请求的副本，ctx取消时中断请求
+func (b BlockChain) WithContext(ctx context.Context) BlockChain {
+	b.ctx = ctx
+	return b
 }
 
 type HeaderHash struct {
@@ -28,9 +37,13 @@ type HeaderHash struct {
 
 type BlockchainStateRpcResult struct {
 	BlockchainState struct {
-		Difficulty                  int  `json:"difficulty"`
-		GenesisChallengeInitialized bool `json:"genesis_challenge_initialized"`
-		MempoolSize                 int  `json:"mempool_size"`
+		Difficulty                  int   `json:"difficulty"`
+		GenesisChallengeInitialized bool  `json:"genesis_challenge_initialized"`
+		MempoolSize                 int   `json:"mempool_size"`
+		MempoolCost                 int64 `json:"mempool_cost"`
+		MempoolFees                 int64 `json:"mempool_fees"`
+		MempoolMaxTotalCost         int64 `json:"mempool_max_total_cost"`
+		BlockMaxCost                int64 `json:"block_max_cost"`
 		Peak                        struct {
 			ChallengeBlockInfoHash string `json:"challenge_block_info_hash"`
 			ChallengeVdfOutput     struct {
@@ -118,7 +131,7 @@ func (b BlockChain) GetBlockchainState() (blockchainStateRpcResult BlockchainSta
 	url := b.BaseUrl + "get_blockchain_state"
 	walletId := WalletId{WalletId: b.WalletId}
 	//发起请求
-	resp, err := utils.PostHttps(url, walletId, "application/json", b.CertPath, b.KeyPath)
+	resp, err := utils.PostHttpsContext(orBackground(b.ctx), url, walletId, "application/json", b.CertPath, b.KeyPath)
 	if err != nil {
 		return
 	}
@@ -134,7 +147,7 @@ func (b BlockChain) GetBlockRecord(headerHashStr string) (blockRecordRpcResult B
 	url := b.BaseUrl + "get_block_record"
 	headerHash := HeaderHash{HeaderHash: headerHashStr}
 	//发起请求
-	resp, err := utils.PostHttps(url, headerHash, "application/json", b.CertPath, b.KeyPath)
+	resp, err := utils.PostHttpsContext(orBackground(b.ctx), url, headerHash, "application/json", b.CertPath, b.KeyPath)
 	if err != nil {
 		return
 	}
@@ -145,114 +158,245 @@ func (b BlockChain) GetBlockRecord(headerHashStr string) (blockRecordRpcResult B
 	return blockRecordRpcResult, err
 }
 
-//MonitorBlockState 监控区块链状态
-func MonitorBlockState(blockChain BlockChain) {
-	var iSRestarted bool
-	var isNeedAutoRecover bool
-	var syncingCount int
+// BlockStateMonitor 区块链状态监控
+type BlockStateMonitor struct {
+	blockChain        BlockChain
+	mu                sync.Mutex //保护以下状态，Check与Run可能并发执行
+	iSRestarted       bool
+	isNeedAutoRecover bool
+	isPeakStale       bool
+	progress          syncProgress
+	detector          forkDetector
+}
+
+// blockState 一次检查获取的区块链状态
+type blockState struct {
+	rpcResult    BlockchainStateRpcResult
+	timestamp    int   //最新交易区块时间
+	timestampErr error //获取最新交易区块时间的错误
+}
+
+// NewBlockStateMonitor 创建区块链状态监控
+func NewBlockStateMonitor(blockChain BlockChain) *BlockStateMonitor {
+	return &BlockStateMonitor{blockChain: blockChain}
+}
+
+// Name 监控名称
+func (m *BlockStateMonitor) Name() string {
+	return "blockchain"
+}
+
+// Schedule 默认调度：每隔blockChainInterval分钟检查
+func (m *BlockStateMonitor) Schedule() monitor.Schedule {
+	return monitor.Every(config.GetConfig().Monitor.BockChainInterval)
+}
+
+// Check 检查区块链同步状态及最新区块时间
+func (m *BlockStateMonitor) Check(ctx context.Context) monitor.Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, result := m.check(m.blockChain.WithContext(ctx))
+	return result
+}
+
+// check 获取区块链状态并生成检查结果，获取失败时result.Err不为空
+func (m *BlockStateMonitor) check(blockChain BlockChain) (blockState, monitor.Result) {
+	var state blockState
+	var err error
+	state.rpcResult, err = blockChain.GetBlockchainState()
+	if err != nil {
+		return state, monitor.ErrorResult(m.Name(), monitor.StatusCritical, "获取区块链状态错误", err)
+	}
+	if !state.rpcResult.Success {
+		return state, monitor.ErrorResult(m.Name(), monitor.StatusCritical, "获取区块链状态失败", errors.New(state.rpcResult.Error))
+	}
+	state.timestamp, state.timestampErr = blockChain.GetCurrentLastBlockTimestamp(state.rpcResult)
+	return state, m.result(state.rpcResult, state.timestamp)
+}
+
+// result 根据区块链状态生成检查结果
+func (m *BlockStateMonitor) result(blockchainStateRpcResult BlockchainStateRpcResult, timestamp int) monitor.Result {
+	//获取配置文件
+	cfg := config.GetConfig()
+	state := blockchainStateRpcResult.BlockchainState
+	var result monitor.Result
+	if state.Sync.Synced {
+		result = monitor.NewResult(m.Name(), monitor.StatusOK, fmt.Sprintf("区块链已同步，高度：%d", state.Peak.Height))
+		result.Metrics["synced"] = 1
+	} else {
+		result = monitor.NewResult(m.Name(), monitor.StatusWarning,
+			fmt.Sprintf("区块链未同步，同步进度：%d/%d", state.Sync.SyncProgressHeight, state.Sync.SyncTipHeight))
+		result.Metrics["synced"] = 0
+		if stalledCount := m.progress.stalledCount(); stalledCount >= cfg.Monitor.SyncStallCount {
+			result.Status = monitor.StatusCritical
+			result.Summary = fmt.Sprintf("%s，同步进度已连续%d次没有变化", result.Summary, stalledCount)
+		}
+	}
+	result.Metrics["height"] = float64(state.Peak.Height)
+	result.Metrics["sync_tip_height"] = float64(state.Sync.SyncTipHeight)
+	result.Metrics["sync_progress_height"] = float64(state.Sync.SyncProgressHeight)
+	result.Metrics["mempool_size"] = float64(state.MempoolSize)
+	if timestamp != 0 {
+		peakAge := time.Since(time.Unix(int64(timestamp), 0))
+		result.Metrics["peak_age_seconds"] = peakAge.Seconds()
+		if peakAge > time.Duration(cfg.Monitor.PeakMaxAgeMinutes)*time.Minute {
+			result.Status = monitor.Worse(result.Status, monitor.StatusWarning)
+			result.Detail = fmt.Sprintf("已经%s没有新的交易区块", formatDuration(peakAge))
+		}
+	}
+	return result
+}
+
+// Run 检查区块链状态，未同步或同步停滞时通知并自动修复
+func (m *BlockStateMonitor) Run(ctx context.Context) monitor.Result {
 	var event string
 	var detail string
 	var remark string
-	var timestamp int
 
 	//获取配置文件
 	cfg := config.GetConfig()
 	machineName := cfg.Monitor.MachineName
 	event = "区块链状态监控"
-	log.Info("Start to monitor block state...")
 
-	for {
-		//获取区块链状态
-		blockchainStateRpcResult, err := blockChain.GetBlockchainState()
-		if err != nil {
-			log.Error("Get blockchain state failed: ", err)
-			detail = err.Error()
-			remark = fmt.Sprintf("获取区块链状态错误，已自动重启%s", cfg.Coin.Name)
-			//发送获取区块链状态错误通知
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blockChain := m.blockChain.WithContext(ctx)
+
+	//获取区块链状态
+	state, result := m.check(blockChain)
+	if result.Err != nil {
+		log.Errorf("%s: %s", result.Summary, result.Err)
+		detail = result.Err.Error()
+		//自动修复
+		remediateResult, remediateErr := remediate(ctx, remediation.ConditionBlockchainState)
+		remark = fmt.Sprintf("%s，%s", result.Summary, remediateResult)
+		//发送获取区块链状态错误通知
+		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+		//只有修复操作实际执行成功时才记录已重启，否则恢复时按自动恢复通知
+		if remediateErr == nil {
+			m.iSRestarted = true
+		} else {
+			m.isNeedAutoRecover = true
+		}
+		result.Summary = remark
+		return result
+	}
+
+	log.Info("Get blockchain state rpc result success!")
+	blockchainStateRpcResult := state.rpcResult
+	//检查最新交易区块时间，与同步状态无关
+	if state.timestampErr == nil {
+		m.isPeakStale = checkPeakAge(state.timestamp, m.isPeakStale)
+	}
+	//区块链已同步
+	if blockchainStateRpcResult.BlockchainState.Sync.Synced {
+		log.Info("Blockchain is synced!")
+		remark = "区块链同步成功"
+		//重启后恢复
+		if m.iSRestarted {
+			// 发送重启恢复微信通知
+			detail = "自动修复后恢复"
+		} else if m.isNeedAutoRecover {
+			// 发送自动恢复微信通知
+			detail = "等待间隔后自动恢复"
+		}
+		if m.iSRestarted || m.isNeedAutoRecover {
+			//发送区块链同步成功恢复微信通知
 			wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-			//重启Chia
-			restartChia()
-			iSRestarted = true
-			//等待间隔时间后重新查询
-			time.Sleep(time.Duration(cfg.Monitor.BockChainInterval) * time.Minute)
-			continue
 		}
-		//获取成功
-		if blockchainStateRpcResult.Success {
-			log.Info("Get blockchain state rpc result success!")
-			//区块链已同步
-			if blockchainStateRpcResult.BlockchainState.Sync.Synced {
-				log.Info("Blockchain is synced!")
-				remark = "区块链同步成功"
-				//重启后恢复
-				if iSRestarted {
-					// 发送重启恢复微信通知
-					detail = fmt.Sprintf("重启%s后恢复", cfg.Coin.Name)
-				} else if isNeedAutoRecover {
-					//未同步计数清零
-					syncingCount = 0
-					// 发送自动恢复微信通知
-					detail = "等待间隔后自动恢复"
-				}
-				if iSRestarted || isNeedAutoRecover {
-					//发送区块链同步成功恢复微信通知
-					wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-				}
-				iSRestarted = false
-				isNeedAutoRecover = false
-			} else {
-				//区块链未同步
-				log.Error("Blockchain is not synced!")
-				log.Infof("Blockchain sync tip height: %d, sync progress height:%d",
-					blockchainStateRpcResult.BlockchainState.Sync.SyncTipHeight,
-					blockchainStateRpcResult.BlockchainState.Sync.SyncProgressHeight)
-				//获取当前最新区块时间
-				timestamp, err = blockChain.GetCurrentLastBlockTimestamp(blockchainStateRpcResult)
-				if err != nil {
-					detail = err.Error()
-					remark = "获取区块记录错误"
-					//发送获取区块记录错误微信通知
-					wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-				}
-				//重试次数+1
-				if syncingCount < syncingCountMax {
-					log.Debugf("Retry count: %d", syncingCount)
-					syncingCount = syncingCount + 1
-					//需要等待自动恢复
-					isNeedAutoRecover = true
-					currentBlockTime := time.Unix(int64(timestamp), 0).Format("2006-01-02 15:04:05")
-					detail = fmt.Sprintf("第%d次等待自动恢复，当前最新区块时间：%s",
-						syncingCount,
-						currentBlockTime)
-					remark = "区块链未同步"
-				} else {
-					log.Infof("已经达到最大等待次数，立即重启%s", cfg.Coin.Name)
-					//发送区块链未同步，已经重新启动微信通知
-					detail = fmt.Sprintf("已经达到最大等待次数，立即重启%s", cfg.Coin.Name)
-					remark = "区块链未同步"
-					//等待syncingCountMax * blockChainInterval后都没有自动恢复，重启Chia
-					go restartChia()
-					iSRestarted = true
-					//重启后继续等待自动恢复，防止暂时未同步成功
-					syncingCount = 0
-				}
-				//发送区块链未同步微信通知
-				wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-			}
+		m.iSRestarted = false
+		m.isNeedAutoRecover = false
+		//区块链已恢复，重置重启退避及修复进度
+		remediation.RecordRecovery()
+		remediation.Reset(remediation.ConditionBlockchainState)
+		remediation.Reset(remediation.ConditionSyncStall)
+		//同步进度记录清零
+		m.progress.reset()
+		//检测链重组及分叉
+		m.detector.check(blockChain, blockchainStateRpcResult)
+		return result
+	}
+
+	//区块链未同步
+	log.Error("Blockchain is not synced!")
+	log.Infof("Blockchain sync tip height: %d, sync progress height:%d",
+		blockchainStateRpcResult.BlockchainState.Sync.SyncTipHeight,
+		blockchainStateRpcResult.BlockchainState.Sync.SyncProgressHeight)
+	if state.timestampErr != nil {
+		detail = state.timestampErr.Error()
+		remark = "获取区块记录错误"
+		//发送获取区块记录错误微信通知
+		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+	}
+	//记录同步进度，只有同步进度停滞时才重启
+	syncState := blockchainStateRpcResult.BlockchainState.Sync
+	m.progress.add(syncState.SyncProgressHeight, time.Now(), cfg.Monitor.SyncStallCount)
+	stalledCount := m.progress.stalledCount()
+	//记录同步进度后重新生成结果，包含本次的停滞次数
+	result = m.result(blockchainStateRpcResult, state.timestamp)
+	if stalledCount < cfg.Monitor.SyncStallCount {
+		log.Debugf("Sync stalled count: %d", stalledCount)
+		//需要等待自动恢复
+		m.isNeedAutoRecover = true
+		currentBlockTime := time.Unix(int64(state.timestamp), 0).Format("2006-01-02 15:04:05")
+		detail = fmt.Sprintf("同步进度：%d/%d，%s，当前最新区块时间：%s",
+			syncState.SyncProgressHeight,
+			syncState.SyncTipHeight,
+			m.progress.describe(syncState.SyncTipHeight),
+			currentBlockTime)
+		if stalledCount > 0 {
+			detail = detail + fmt.Sprintf("，同步进度已连续%d次没有变化", stalledCount)
+		}
+		remark = "区块链未同步"
+	} else {
+		log.Infof("同步进度已连续%d次没有变化，立即自动修复", stalledCount)
+		//发送区块链未同步，已经重新启动微信通知
+		//同步进度停滞syncStallCount * blockChainInterval后，自动修复
+		remediateResult, remediateErr := remediate(ctx, remediation.ConditionSyncStall)
+		detail = fmt.Sprintf("同步进度%d已连续%d次没有变化，%s", syncState.SyncProgressHeight, stalledCount, remediateResult)
+		remark = "区块链同步停滞"
+		if remediateErr == nil {
+			m.iSRestarted = true
 		} else {
-			//获取失败
-			log.Error("Get blockchain state rpc result failed: ", blockchainStateRpcResult.Error)
-			detail = blockchainStateRpcResult.Error
-			remark = fmt.Sprintf("获取区块链状态失败，已自动重启%s", cfg.Coin.Name)
-			//发送获取rpc失败微信通知
-			wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-			//重启Chia
-			restartChia()
-			iSRestarted = true
+			m.isNeedAutoRecover = true
 		}
+		//重新记录同步进度，重启后防止暂时未同步成功，未重启时等待再次停滞syncStallCount次
+		m.progress.reset()
+	}
+	//发送区块链未同步微信通知
+	wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+	result.Detail = detail
+	return result
+}
 
-		time.Sleep(time.Duration(cfg.Monitor.BockChainInterval) * time.Minute)
+// checkPeakAge 最新交易区块时间超过配置的分钟数时通知，恢复时发送恢复通知，返回最新区块是否过期
+func checkPeakAge(timestamp int, isPeakStale bool) bool {
+	//获取配置文件
+	cfg := config.GetConfig()
+	machineName := cfg.Monitor.MachineName
+	event := "最新区块时间监控"
+
+	if timestamp == 0 {
+		return isPeakStale
+	}
+	peakTime := time.Unix(int64(timestamp), 0)
+	peakAge := time.Since(peakTime)
+	maxAge := time.Duration(cfg.Monitor.PeakMaxAgeMinutes) * time.Minute
+	if peakAge > maxAge {
+		log.Errorf("Peak is stale, last transaction block time: %s", peakTime.Format("2006-01-02 15:04:05"))
+		if !isPeakStale {
+			detail := fmt.Sprintf("最新交易区块时间：%s，已经%s没有新的交易区块", peakTime.Format("2006-01-02 15:04:05"), formatDuration(peakAge))
+			remark := fmt.Sprintf("最新区块超过%d分钟未更新，请检查节点连接", cfg.Monitor.PeakMaxAgeMinutes)
+			wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+		}
+		return true
+	}
+	if isPeakStale {
+		detail := fmt.Sprintf("最新交易区块时间：%s", peakTime.Format("2006-01-02 15:04:05"))
+		remark := "最新区块已恢复更新"
+		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
 	}
+	return false
 }
 
 // GetCurrentLastBlockTimestamp 获取当前最新区块时间
@@ -295,16 +439,3 @@ func (b BlockChain) GetCurrentLastBlockTimestamp(blockchainStateRpcResult Blockc
 
 	return timestamp, err
 }
-
-//TestNodeEvent 测试节点事件
-func TestNodeEvent() {
-	//获取配置文件
-	cfg := config.GetConfig()
-
-	machineName := cfg.Monitor.MachineName
-	event := "node测试事件"
-	detail := "node测试详情"
-	remark := "node测试备注"
-	//发送测试通知
-	wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-}
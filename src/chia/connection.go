@@ -0,0 +1,265 @@
+package chia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+	"chia_monitor/src/monitor"
+	"chia_monitor/src/remediation"
+	"chia_monitor/src/utils"
+	"chia_monitor/src/wechat"
+)
+
+// 节点类型
+const (
+	NodeTypeFullNode   = 1
+	NodeTypeHarvester  = 2
+	NodeTypeFarmer     = 3
+	NodeTypeTimelord   = 4
+	NodeTypeIntroducer = 5
+	NodeTypeWallet     = 6
+)
+
+// Connection 节点连接
+type Connection struct {
+	Type           int    `json:"type"`
+	NodeId         string `json:"node_id"`
+	PeerHost       string `json:"peer_host"`
+	PeerPort       int    `json:"peer_port"`
+	PeerServerPort int    `json:"peer_server_port"`
+	LocalPort      int    `json:"local_port"`
+	BytesRead      int64  `json:"bytes_read"`
+	BytesWritten   int64  `json:"bytes_written"`
+}
+
+// ConnectionsRpcResult 获取连接返回
+type ConnectionsRpcResult struct {
+	Connections []Connection `json:"connections"`
+	Error       string       `json:"error"`
+	Success     bool         `json:"success"`
+}
+
+// OpenConnectionRequest 连接节点请求
+type OpenConnectionRequest struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// RpcResult 只关心是否成功的rpc返回
+type RpcResult struct {
+	Error   string `json:"error"`
+	Success bool   `json:"success"`
+}
+
+// ConnectionCount 各类型节点的连接数
+type ConnectionCount struct {
+	FullNode  int
+	Farmer    int
+	Wallet    int
+	Harvester int
+	Other     int
+}
+
+// GetConnections 获取全节点的连接
+func (b BlockChain) GetConnections() (connectionsRpcResult ConnectionsRpcResult, err error) {
+	url := b.BaseUrl + "get_connections"
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(b.ctx), url, struct{}{}, "application/json", b.CertPath, b.KeyPath)
+	if err != nil {
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &connectionsRpcResult)
+
+	return connectionsRpcResult, err
+}
+
+// OpenConnection 主动连接节点
+func (b BlockChain) OpenConnection(host string, port int) (rpcResult RpcResult, err error) {
+	url := b.BaseUrl + "open_connection"
+	openConnectionRequest := OpenConnectionRequest{Host: host, Port: port}
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(b.ctx), url, openConnectionRequest, "application/json", b.CertPath, b.KeyPath)
+	if err != nil {
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &rpcResult)
+
+	return rpcResult, err
+}
+
+// CountConnections 按节点类型统计连接数
+func CountConnections(connections []Connection) (count ConnectionCount) {
+	for _, connection := range connections {
+		switch connection.Type {
+		case NodeTypeFullNode:
+			count.FullNode++
+		case NodeTypeFarmer:
+			count.Farmer++
+		case NodeTypeWallet:
+			count.Wallet++
+		case NodeTypeHarvester:
+			count.Harvester++
+		default:
+			count.Other++
+		}
+	}
+	return count
+}
+
+// String 连接数描述
+func (c ConnectionCount) String() string {
+	return fmt.Sprintf("全节点：%d，农民：%d，钱包：%d，收割机：%d", c.FullNode, c.Farmer, c.Wallet, c.Harvester)
+}
+
+// openTrustedPeers 主动连接配置的节点，返回连接结果
+func openTrustedPeers(blockChain BlockChain, peers []string) string {
+	var results []string
+	for _, peer := range peers {
+		host, portStr, err := net.SplitHostPort(peer)
+		if err != nil {
+			log.Errorf("Invalid trusted peer %s: %s", peer, err)
+			results = append(results, fmt.Sprintf("%s 地址错误", peer))
+			continue
+		}
+		port, _ := strconv.Atoi(portStr)
+		if remediation.IsDryRun("open connection to %s", peer) {
+			results = append(results, fmt.Sprintf("%s 演练模式，未实际连接", peer))
+			continue
+		}
+		rpcResult, err := blockChain.OpenConnection(host, port)
+		if err != nil {
+			log.Errorf("Open connection to %s err: %s", peer, err)
+			results = append(results, fmt.Sprintf("%s 连接错误：%s", peer, err))
+		} else if !rpcResult.Success {
+			log.Errorf("Open connection to %s failed: %s", peer, rpcResult.Error)
+			results = append(results, fmt.Sprintf("%s 连接失败：%s", peer, rpcResult.Error))
+		} else {
+			log.Infof("Open connection to %s success", peer)
+			results = append(results, fmt.Sprintf("%s 连接成功", peer))
+		}
+	}
+	return strings.Join(results, "\n")
+}
+
+// ConnectionMonitor 全节点连接监控
+type ConnectionMonitor struct {
+	blockChain   BlockChain
+	mu           sync.Mutex //保护以下状态，防止重新调度时两次Run并发执行
+	lowPeerCount int
+	isLowPeer    bool
+}
+
+// NewConnectionMonitor 创建全节点连接监控
+func NewConnectionMonitor(blockChain BlockChain) *ConnectionMonitor {
+	return &ConnectionMonitor{blockChain: blockChain}
+}
+
+// Name 监控名称
+func (m *ConnectionMonitor) Name() string {
+	return "connections"
+}
+
+// Schedule 默认调度：每隔blockChainInterval分钟检查
+func (m *ConnectionMonitor) Schedule() monitor.Schedule {
+	return monitor.Every(config.GetConfig().Monitor.BockChainInterval)
+}
+
+// Check 检查全节点连接数
+func (m *ConnectionMonitor) Check(ctx context.Context) monitor.Result {
+	_, result := m.check(m.blockChain.WithContext(ctx))
+	return result
+}
+
+// check 获取连接数并生成检查结果，获取失败时result.Err不为空
+func (m *ConnectionMonitor) check(blockChain BlockChain) (ConnectionCount, monitor.Result) {
+	connectionsRpcResult, err := blockChain.GetConnections()
+	if err != nil {
+		return ConnectionCount{}, monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "获取节点连接错误", err)
+	}
+	if !connectionsRpcResult.Success {
+		return ConnectionCount{}, monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "获取节点连接失败", errors.New(connectionsRpcResult.Error))
+	}
+	count := CountConnections(connectionsRpcResult.Connections)
+	return count, m.result(count)
+}
+
+// result 根据连接数生成检查结果
+func (m *ConnectionMonitor) result(count ConnectionCount) monitor.Result {
+	//获取配置文件
+	cfg := config.GetConfig()
+	result := monitor.NewResult(m.Name(), monitor.StatusOK, count.String())
+	if count.FullNode < cfg.ConnectionMonitor.MinFullNodePeers {
+		result.Status = monitor.StatusWarning
+		result.Summary = fmt.Sprintf("全节点连接数：%d，低于%d", count.FullNode, cfg.ConnectionMonitor.MinFullNodePeers)
+		result.Detail = count.String()
+	}
+	result.Metrics["full_node_peers"] = float64(count.FullNode)
+	result.Metrics["farmer_peers"] = float64(count.Farmer)
+	result.Metrics["wallet_peers"] = float64(count.Wallet)
+	result.Metrics["harvester_peers"] = float64(count.Harvester)
+	return result
+}
+
+//Run 检查全节点的连接，全节点连接数不足时先主动连接配置的节点，仍然不足时执行自动修复
+func (m *ConnectionMonitor) Run(ctx context.Context) monitor.Result {
+	var event string
+	var detail string
+	var remark string
+
+	//获取配置文件
+	cfg := config.GetConfig()
+	machineName := cfg.Monitor.MachineName
+	event = "节点连接监控"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blockChain := m.blockChain.WithContext(ctx)
+
+	count, result := m.check(blockChain)
+	if result.Err != nil {
+		log.Errorf("%s: %s", result.Summary, result.Err)
+		return result
+	}
+	log.Infof("Connections: %+v", count)
+	if count.FullNode < cfg.ConnectionMonitor.MinFullNodePeers {
+		m.isLowPeer = true
+		m.lowPeerCount = m.lowPeerCount + 1
+		detail = fmt.Sprintf("全节点连接数：%d，低于%d，当前连接 %s", count.FullNode, cfg.ConnectionMonitor.MinFullNodePeers, count)
+		if m.lowPeerCount < cfg.ConnectionMonitor.RestartAfter && len(cfg.ConnectionMonitor.TrustedPeers) > 0 {
+			//先尝试主动连接配置的节点
+			detail = detail + "\n" + openTrustedPeers(blockChain, cfg.ConnectionMonitor.TrustedPeers)
+			remark = fmt.Sprintf("全节点连接数不足，第%d次尝试主动连接节点", m.lowPeerCount)
+		} else if m.lowPeerCount >= cfg.ConnectionMonitor.RestartAfter {
+			remediateResult, _ := remediate(ctx, remediation.ConditionLowPeers)
+			remark = fmt.Sprintf("全节点连接数已连续%d次不足，%s", m.lowPeerCount, remediateResult)
+			m.lowPeerCount = 0
+		} else {
+			remark = fmt.Sprintf("全节点连接数不足，第%d次等待自动恢复", m.lowPeerCount)
+		}
+		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+		result.Detail = detail + "\n" + remark
+		return result
+	}
+	if m.isLowPeer {
+		detail = fmt.Sprintf("当前连接 %s", count)
+		remark = "全节点连接数已恢复"
+		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+	}
+	m.isLowPeer = false
+	m.lowPeerCount = 0
+	remediation.Reset(remediation.ConditionLowPeers)
+	return result
+}
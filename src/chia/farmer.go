@@ -1,17 +1,20 @@
 package chia
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
-	"os"
-	"time"
+	"strings"
+	"sync"
 
-	"github.com/robfig/cron"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"chia_monitor/src/config"
+	"chia_monitor/src/monitor"
+	"chia_monitor/src/remediation"
 	"chia_monitor/src/utils"
 	"chia_monitor/src/wechat"
 )
@@ -25,6 +28,13 @@ type Farmer struct {
 	CertPath              string
 	KeyPath               string
 	IsSearchForPrivateKey bool
+	ctx                   context.Context //rpc请求使用的ctx，为空时不可取消
+}
+
+// WithContext 返回使用ctx发起rpc请求的副本，ctx取消时中断请求
+func (f Farmer) WithContext(ctx context.Context) Farmer {
+	f.ctx = ctx
+	return f
 }
 
 type HarvestersRpcResult struct {
@@ -42,6 +52,16 @@ type HarvestersRpcResult struct {
 	Success bool   `json:"success"`
 }
 
+// HarvesterInfo 已连接收割机的状态
+type HarvesterInfo struct {
+	Host         string `json:"host"`
+	Port         int    `json:"port"`
+	NodeId       string `json:"node_id"`
+	Plots        int    `json:"plots"`
+	FailedToOpen int    `json:"failed_to_open"` //打开失败的图表数
+	NoKey        int    `json:"no_key"`         //缺少密钥的图表数
+}
+
 // PoolStateRpcResult 获取矿池状态
 type PoolStateRpcResult struct {
 	PoolState []struct {
@@ -73,142 +93,219 @@ type PoolStateRpcResult struct {
 	Success bool   `json:"success"`
 }
 
-//MonitorFarmer 监控耕种状态
-func MonitorFarmer(farmer Farmer) {
+// FarmerMonitor 耕种状态监控，配置的收割机掉线时通知
+type FarmerMonitor struct {
+	farmer                 Farmer
+	mu                     sync.Mutex //保护isWaitHarvesterRecover，防止重新调度时两次Run并发执行
+	isWaitHarvesterRecover bool
+}
+
+// NewFarmerMonitor 创建耕种状态监控
+func NewFarmerMonitor(farmer Farmer) *FarmerMonitor {
+	return &FarmerMonitor{farmer: farmer}
+}
+
+// ClearHarvesterOfflineFlag 删除之前的收割机掉线标识文件，只在守护进程启动时调用，单次检查不能删除运行中的守护进程的标识
+func ClearHarvesterOfflineFlag() {
+	//获取配置文件
+	cfg := config.GetConfig()
+	if utils.Exists(cfg.Monitor.HarvesterOfflineFlag) {
+		_ = remediation.RemoveFile(cfg.Monitor.HarvesterOfflineFlag)
+	}
+}
+
+// Name 监控名称
+func (m *FarmerMonitor) Name() string {
+	return "farmer"
+}
+
+// Schedule 默认调度：每隔farmerInterval分钟检查
+func (m *FarmerMonitor) Schedule() monitor.Schedule {
+	return monitor.Every(config.GetConfig().Monitor.FarmerInterval)
+}
+
+// Check 检查配置的收割机是否在线
+func (m *FarmerMonitor) Check(ctx context.Context) monitor.Result {
+	_, result := m.check(m.farmer.WithContext(ctx))
+	return result
+}
+
+// check 获取掉线的收割机并生成检查结果，获取失败时result.Err不为空
+func (m *FarmerMonitor) check(farmer Farmer) ([]string, monitor.Result) {
+	harvestersRpcResult, err := farmer.GetHarvesters()
+	if err != nil {
+		return nil, monitor.ErrorResult(m.Name(), monitor.StatusCritical, "获取收割机列表错误", err)
+	}
+	if !harvestersRpcResult.Success {
+		return nil, monitor.ErrorResult(m.Name(), monitor.StatusCritical, "获取收割机列表失败", errors.New(harvestersRpcResult.Error))
+	}
+	offline := offlineHarvesters(harvestersRpcResult)
+	return offline, m.result(harvestersRpcResult, offline)
+}
+
+// result 根据掉线的收割机生成检查结果
+func (m *FarmerMonitor) result(harvestersRpcResult HarvestersRpcResult, offline []string) monitor.Result {
+	result := monitor.NewResult(m.Name(), monitor.StatusOK, fmt.Sprintf("收割机已经全部上线，在线%d台", len(harvestersRpcResult.Harvesters)))
+	if len(offline) > 0 {
+		result.Status = monitor.StatusCritical
+		result.Summary = fmt.Sprintf("%d台收割机掉线", len(offline))
+		result.Detail = strings.Join(offline, "\n")
+	}
+	var plots int
+	for _, harvester := range harvestersRpcResult.Harvesters {
+		plots = plots + len(harvester.Plots)
+	}
+	result.Metrics["harvesters"] = float64(len(harvestersRpcResult.Harvesters))
+	result.Metrics["harvesters_offline"] = float64(len(offline))
+	result.Metrics["plots"] = float64(plots)
+	return result
+}
+
+// offlineHarvesters 配置的收割机中不在农民连接列表里的收割机，域名解析失败的跳过
+func offlineHarvesters(harvestersRpcResult HarvestersRpcResult) (offline []string) {
+	var host string
+	//获取配置文件
+	cfg := config.GetConfig()
+	for _, harvesterMonitor := range cfg.Monitor.HarvesterList {
+		isFarming := false
+		address := net.ParseIP(harvesterMonitor)
+		if address == nil {
+			// 没有匹配上，实际为域名，需要解析ip地址
+			addr, err := net.ResolveIPAddr("ip", harvesterMonitor)
+			if err != nil {
+				log.Errorf("%s resolve failed", harvesterMonitor)
+				continue
+			}
+			host = addr.String()
+			log.Debugf("%s resolve to ip is %s", harvesterMonitor, addr)
+		} else {
+			// 匹配成功，为IP地址
+			host = address.String()
+		}
+		for _, harvester := range harvestersRpcResult.Harvesters {
+			if host == harvester.Connection.Host {
+				log.Debugf("%s is farming, ok", harvesterMonitor)
+				isFarming = true
+			}
+		}
+		if isFarming == false {
+			log.Errorf("%s is not farming", harvesterMonitor)
+			offline = append(offline, harvesterMonitor)
+		}
+	}
+	return offline
+}
+
+// Run 检查收割机状态，掉线信息有变化时才重新发送通知
+func (m *FarmerMonitor) Run(ctx context.Context) monitor.Result {
 	var event string
 	var detail string
 	var remark string
-	var isFarming bool
-	var harvesterOfflineCount int
-	var host string
-	var isWaitHarvesterRecover bool
 
 	//获取配置文件
 	cfg := config.GetConfig()
 	machineName := cfg.Monitor.MachineName
 	event = "耕种状态监控"
-	log.Info("Start to monitor farmer...")
 
-	//删除之前的收割机掉线标识文件
-	if utils.Exists(cfg.Monitor.HarvesterOfflineFlag) {
-		_ = os.Remove(cfg.Monitor.HarvesterOfflineFlag)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//获取收割机状态
+	offline, result := m.check(m.farmer.WithContext(ctx))
+	if result.Err != nil {
+		log.Errorf("%s: %s", result.Summary, result.Err)
+		//发送错误通知
+		wechat.SendChiaMonitorNoticeToWechat(machineName, event, result.Err.Error(), result.Summary)
+		return result
 	}
 
-	for {
-		//获取收割机状态
-		harvestersRpcResult, err := farmer.GetHarvesters()
-		if err != nil {
-			log.Error("Get blockchain state failed: ", err)
+	log.Info("Get harvest list rpc result success!")
+	if len(offline) > 0 {
+		m.isWaitHarvesterRecover = true
+		//发送掉线通知后再对掉线的收割机执行自动修复
+		defer m.remediateHarvesters(ctx, offline)
+		detail = fmt.Sprintf("%d台设备掉线：\n%s", len(offline), strings.Join(offline, "\n"))
+		remark = "收割机掉线，请及时登陆设备处理"
+		//不存在标识位，直接发送通知
+		if !utils.Exists(cfg.Monitor.HarvesterOfflineFlag) {
 			//发送错误通知
-			detail = err.Error()
-			remark = "获取收割机列表错误"
 			wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-			//等待间隔时间后重新查询
-			time.Sleep(time.Duration(cfg.BockChainInterval) * time.Minute)
-			continue
+			//写入标识位文件
+			writeHarvesterOfflineFlag(detail)
+			return result
 		}
-		//获取成功
-		if harvestersRpcResult.Success {
-			log.Info("Get harvest list rpc result success!")
-			harvesterOfflineCount = 0
-			detailTmp := "设备掉线："
-			remark = "收割机掉线，请及时登陆设备处理"
-			//查找配置里面的本地+固定IP三台
-			for _, harvesterMonitor := range cfg.Monitor.HarvesterList {
-				isFarming = false
-				address := net.ParseIP(harvesterMonitor)
-				if address == nil {
-					// 没有匹配上，实际为域名，需要解析ip地址
-					addr, err := net.ResolveIPAddr("ip", harvesterMonitor)
-					if err != nil {
-						log.Errorf("%s resolve failed", harvesterMonitor)
-						continue
-					}
-					host = addr.String()
-					log.Debugf("%s resolve to ip is %s", harvesterMonitor, addr)
-				} else {
-					// 匹配成功，为IP地址
-					host = address.String()
-				}
-				for _, harvester := range harvestersRpcResult.Harvesters {
-					if host == harvester.Connection.Host {
-						log.Debugf("%s is farming, ok", harvesterMonitor)
-						isFarming = true
-					}
-				}
-				if isFarming == false {
-					log.Errorf("%s is not farming", harvesterMonitor)
-					harvesterOfflineCount = harvesterOfflineCount + 1
-					detailTmp = detailTmp + "\n" + harvesterMonitor
-				}
-			}
-			//查找lj.yasin.store
-			if harvesterOfflineCount > 0 {
-				isWaitHarvesterRecover = true
-				detail = fmt.Sprintf("%d台%s", harvesterOfflineCount, detailTmp)
-				//不存在标识位，直接发送通知
-				if !utils.Exists(cfg.Monitor.HarvesterOfflineFlag) {
-					//发送错误通知
-					wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-					//写入标识位文件
-					err = ioutil.WriteFile(cfg.Monitor.HarvesterOfflineFlag, []byte(detail), 0644)
-					if err != nil {
-						log.Errorf("Write file [%s] failed: %s", cfg.Monitor.HarvesterOfflineFlag, err)
-					} else {
-						log.Infof("Write file [%s] success", cfg.Monitor.HarvesterOfflineFlag)
-					}
-				} else {
-					//存在标识位，判断与当前记录的flag内容是否一致，有变化时才重新发送通知
-					file, err := ioutil.ReadFile(cfg.Monitor.HarvesterOfflineFlag)
-					if err != nil {
-						log.Errorf("Open file [%s] failed: %s", cfg.Monitor.HarvesterOfflineFlag, err)
-						continue
-					}
-					if detail == string(file) {
-						log.Debug("Same harvester offline info, do not send notice to wechat")
-					} else {
-						log.Info("Different harvester offline info, send notice to wechat")
-						//发送错误通知
-						wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-						//写入标识位文件
-						err = ioutil.WriteFile(cfg.Monitor.HarvesterOfflineFlag, []byte(detail), 0644)
-						if err != nil {
-							log.Errorf("Write file [%s] failed: %s", cfg.Monitor.HarvesterOfflineFlag, err)
-						} else {
-							log.Infof("Write file [%s] success", cfg.Monitor.HarvesterOfflineFlag)
-						}
-					}
-				}
-			} else {
-				log.Info("All harvesters are online!")
-				//是否从异常中恢复，是的话发送微信通知
-				if isWaitHarvesterRecover {
-					detail = "收割机已经全部上线"
-					remark = "收割机已恢复"
-					//发送微信通知
-					wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-					//重置收割机掉线状态
-					isWaitHarvesterRecover = false
-					//删除收割机掉线标识位文件
-					err = os.Remove(cfg.Monitor.HarvesterOfflineFlag)
-					if err != nil {
-						log.Error("Remove harvester offline flag err: ", err)
-					} else {
-						log.Debug("Remove harvester offline flag success!")
-					}
-				}
-			}
+		//存在标识位，判断与当前记录的flag内容是否一致，有变化时才重新发送通知
+		file, err := ioutil.ReadFile(cfg.Monitor.HarvesterOfflineFlag)
+		if err != nil {
+			log.Errorf("Open file [%s] failed: %s", cfg.Monitor.HarvesterOfflineFlag, err)
+			return result
+		}
+		if detail == string(file) {
+			log.Debug("Same harvester offline info, do not send notice to wechat")
 		} else {
-			log.Error("Get blockchain state rpc result failed: ", harvestersRpcResult.Error)
-			//发送获取rpc失败微信通知
-			detail = harvestersRpcResult.Error
-			remark = "获取收割机列表失败"
+			log.Info("Different harvester offline info, send notice to wechat")
+			//发送错误通知
 			wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+			//写入标识位文件
+			writeHarvesterOfflineFlag(detail)
 		}
+		return result
+	}
 
-		time.Sleep(time.Duration(cfg.Monitor.FarmerInterval) * time.Minute)
+	log.Info("All harvesters are online!")
+	//是否从异常中恢复，是的话发送微信通知
+	if m.isWaitHarvesterRecover {
+		detail = "收割机已经全部上线"
+		remark = "收割机已恢复"
+		//发送微信通知
+		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+		//重置收割机掉线状态及修复进度
+		m.isWaitHarvesterRecover = false
+		remediation.Reset(remediation.ConditionHarvesterOffline)
+		//删除收割机掉线标识位文件
+		err := remediation.RemoveFile(cfg.Monitor.HarvesterOfflineFlag)
+		if err != nil {
+			log.Error("Remove harvester offline flag err: ", err)
+		} else {
+			log.Debug("Remove harvester offline flag success!")
+		}
 	}
+	return result
+}
 
+// writeHarvesterOfflineFlag 写入收割机掉线标识位文件
+func writeHarvesterOfflineFlag(detail string) {
+	//获取配置文件
+	cfg := config.GetConfig()
+	err := ioutil.WriteFile(cfg.Monitor.HarvesterOfflineFlag, []byte(detail), 0644)
+	if err != nil {
+		log.Errorf("Write file [%s] failed: %s", cfg.Monitor.HarvesterOfflineFlag, err)
+	} else {
+		log.Infof("Write file [%s] success", cfg.Monitor.HarvesterOfflineFlag)
+	}
+}
+
+//remediateHarvesters 对掉线的收割机依次执行配置的修复操作，在Run中同步执行，退出时等待修复结束，有修复操作实际执行时发送通知
+func (m *FarmerMonitor) remediateHarvesters(ctx context.Context, harvesters []string) {
+	if len(harvesters) == 0 || !remediation.HasActions(remediation.ConditionHarvesterOffline) {
+		return
+	}
+	var results []string
+	for _, harvester := range harvesters {
+		result, err := remediation.Escalate(ctx, remediation.ConditionHarvesterOffline, map[string]string{"harvester": harvester})
+		//退避、演练模式等未实际执行的修复操作只记录日志
+		if remediation.Skipped(err) {
+			log.Infof("Remediation of harvester %s skipped: %s", harvester, result)
+			continue
+		}
+		results = append(results, fmt.Sprintf("%s：%s", harvester, result))
+	}
+	if len(results) == 0 {
+		return
+	}
+	//获取配置文件
+	cfg := config.GetConfig()
+	wechat.SendChiaMonitorNoticeToWechat(cfg.Monitor.MachineName, "收割机自动修复", strings.Join(results, "\n"), "收割机掉线，已执行自动修复")
 }
 
 // GetRewardTargets 获取奖励地址
@@ -216,7 +313,7 @@ func (f Farmer) GetRewardTargets() {
 	url := f.BaseUrl + "get_reward_targets"
 	searchForPrivateKey := &SearchForPrivateKey{SearchForPrivateKey: f.IsSearchForPrivateKey}
 	//发起请求
-	resp, err := utils.PostHttps(url, searchForPrivateKey, "application/json", f.CertPath, f.KeyPath)
+	resp, err := utils.PostHttpsContext(orBackground(f.ctx), url, searchForPrivateKey, "application/json", f.CertPath, f.KeyPath)
 	if err != nil {
 		log.Error(err)
 		return
@@ -229,7 +326,7 @@ func (f Farmer) GetPoolState() (poolStateRpcResult PoolStateRpcResult, err error
 	url := f.BaseUrl + "get_pool_state"
 	searchForPrivateKey := &SearchForPrivateKey{SearchForPrivateKey: f.IsSearchForPrivateKey}
 	//发起请求
-	resp, err := utils.PostHttps(url, searchForPrivateKey, "application/json", f.CertPath, f.KeyPath)
+	resp, err := utils.PostHttpsContext(orBackground(f.ctx), url, searchForPrivateKey, "application/json", f.CertPath, f.KeyPath)
 	if err != nil {
 		log.Error(err)
 		return
@@ -241,12 +338,34 @@ func (f Farmer) GetPoolState() (poolStateRpcResult PoolStateRpcResult, err error
 	return poolStateRpcResult, err
 }
 
+// Harvesters 获取已连接的收割机及配置中掉线的收割机
+func (f Farmer) Harvesters() (harvesters []HarvesterInfo, offline []string, err error) {
+	harvestersRpcResult, err := f.GetHarvesters()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !harvestersRpcResult.Success {
+		return nil, nil, errors.New(harvestersRpcResult.Error)
+	}
+	for _, harvester := range harvestersRpcResult.Harvesters {
+		harvesters = append(harvesters, HarvesterInfo{
+			Host:         harvester.Connection.Host,
+			Port:         harvester.Connection.Port,
+			NodeId:       harvester.Connection.NodeID,
+			Plots:        len(harvester.Plots),
+			FailedToOpen: len(harvester.FailedToOpenFilenames),
+			NoKey:        len(harvester.NoKeyFilenames),
+		})
+	}
+	return harvesters, offlineHarvesters(harvestersRpcResult), nil
+}
+
 // GetHarvesters 获取收割机状态
 func (f Farmer) GetHarvesters() (harvestersRpcResult HarvestersRpcResult, err error) {
 	url := f.BaseUrl + "get_harvesters"
 	searchForPrivateKey := &SearchForPrivateKey{SearchForPrivateKey: f.IsSearchForPrivateKey}
 	//发起请求
-	resp, err := utils.PostHttps(url, searchForPrivateKey, "application/json", f.CertPath, f.KeyPath)
+	resp, err := utils.PostHttpsContext(orBackground(f.ctx), url, searchForPrivateKey, "application/json", f.CertPath, f.KeyPath)
 	if err != nil {
 		log.Error(err)
 		return
@@ -258,55 +377,100 @@ func (f Farmer) GetHarvesters() (harvestersRpcResult HarvestersRpcResult, err er
 	return harvestersRpcResult, err
 }
 
-//MonitorPool 监控矿池状态
-func MonitorPool(farmer Farmer) {
-	var event string
+// PoolReportMonitor 矿池日报，每日发送矿池状态
+type PoolReportMonitor struct {
+	farmer Farmer
+}
+
+// NewPoolReportMonitor 创建矿池日报
+func NewPoolReportMonitor(farmer Farmer) *PoolReportMonitor {
+	return &PoolReportMonitor{farmer: farmer}
+}
+
+// Name 监控名称
+func (m *PoolReportMonitor) Name() string {
+	return "poolReport"
+}
+
+// Schedule 默认调度：按dailyCron每日发送
+func (m *PoolReportMonitor) Schedule() monitor.Schedule {
+	return monitor.Cron(config.GetConfig().Monitor.DailyCron)
+}
+
+// Check 获取矿池状态
+func (m *PoolReportMonitor) Check(ctx context.Context) monitor.Result {
+	_, result := m.check(m.farmer.WithContext(ctx))
+	return result
+}
+
+// check 获取矿池状态并生成检查结果，获取失败时result.Err不为空
+func (m *PoolReportMonitor) check(farmer Farmer) (PoolStateRpcResult, monitor.Result) {
+	poolStateRpcResult, err := farmer.GetPoolState()
+	if err != nil {
+		return poolStateRpcResult, monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "矿池状态获取错误", err)
+	}
+	if !poolStateRpcResult.Success {
+		return poolStateRpcResult, monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "获取矿池状态失败", errors.New(poolStateRpcResult.Error))
+	}
+	return poolStateRpcResult, m.result(poolStateRpcResult)
+}
+
+// result 根据矿池状态生成检查结果，24h有矿池错误时为Warning
+func (m *PoolReportMonitor) result(poolStateRpcResult PoolStateRpcResult) monitor.Result {
+	if len(poolStateRpcResult.PoolState) == 0 {
+		return monitor.NewResult(m.Name(), monitor.StatusUnknown, "未加入任何矿池")
+	}
+	poolState := poolStateRpcResult.PoolState[0]
+	var successPercent float64
+	if len(poolState.PointsFound24H) > 0 {
+		successPercent = float64(len(poolState.PointsAcknowledged24H)) / float64(len(poolState.PointsFound24H)) * 100
+	}
+	result := monitor.NewResult(m.Name(), monitor.StatusOK, fmt.Sprintf("%s，当前难度：%d，当前积分：%d，24h积分获取成功率：%.2f%%",
+		poolState.PoolConfig.PoolURL,
+		poolState.CurrentDifficulty,
+		poolState.CurrentPoints,
+		successPercent,
+	))
+	if len(poolState.PoolErrors24H) > 0 {
+		result.Status = monitor.StatusWarning
+		result.Detail = fmt.Sprintf("24h矿池错误：%d次，最近一次：%s", len(poolState.PoolErrors24H),
+			poolState.PoolErrors24H[len(poolState.PoolErrors24H)-1].ErrorMessage)
+	}
+	result.Metrics["pool_difficulty"] = float64(poolState.CurrentDifficulty)
+	result.Metrics["pool_points"] = float64(poolState.CurrentPoints)
+	result.Metrics["pool_points_success_percent"] = successPercent
+	result.Metrics["pool_errors_24h"] = float64(len(poolState.PoolErrors24H))
+	return result
+}
+
+// Run 发送矿池状态
+func (m *PoolReportMonitor) Run(ctx context.Context) monitor.Result {
 	var detail string
 	var remark string
 
 	//获取配置文件
 	cfg := config.GetConfig()
 	machineName := cfg.Monitor.MachineName
-	event = "监控矿池状态"
+	event := "监控矿池状态"
 
-	//创建定时任务
-	c := cron.New()
-	err := c.AddFunc(cfg.Monitor.DailyCron, func() {
-		//获取矿池状态
-		poolStateRpcResult, err := farmer.GetPoolState()
-		if err != nil {
-			log.Error("Get pool state err: ", err)
-			//发送获取rpc失败微信通知
-			detail = err.Error()
-			remark = "矿池状态获取错误"
-		} else {
-			//获取成功
-			if poolStateRpcResult.Success {
-				log.Info("Get pool state rpc result success!")
-				//发送矿池状态微信通知
-				successPercent := float64(len(poolStateRpcResult.PoolState[0].PointsAcknowledged24H)) / float64(len(poolStateRpcResult.PoolState[0].PointsFound24H)) * 100
-				detail = fmt.Sprintf("%s，当前难度：%d，当前积分：%d，24h积分获取成功率：%.2f%%",
-					poolStateRpcResult.PoolState[0].PoolConfig.PoolURL,
-					poolStateRpcResult.PoolState[0].CurrentDifficulty,
-					poolStateRpcResult.PoolState[0].CurrentPoints,
-					successPercent,
-				)
-				remark = "获取矿池状态成功"
-			} else {
-				log.Info("Get pool state rpc result failed: ", poolStateRpcResult.Error)
-				//发送获取钱包余额微信通知
-				detail = poolStateRpcResult.Error
-				remark = "获取矿池状态失败"
-			}
+	//获取矿池状态
+	poolStateRpcResult, result := m.check(m.farmer.WithContext(ctx))
+	if result.Err != nil {
+		log.Errorf("%s: %s", result.Summary, result.Err)
+		//发送获取rpc失败微信通知
+		detail = result.Err.Error()
+		remark = result.Summary
+	} else {
+		//获取成功
+		log.Info("Get pool state rpc result success!")
+		//发送矿池状态微信通知
+		detail = result.Summary
+		//通过官方矿池协议获取矿池信息
+		if poolProtocolDetail := getPoolProtocolDetail(ctx, poolStateRpcResult); poolProtocolDetail != "" {
+			detail = detail + "\n" + poolProtocolDetail
 		}
-		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-	})
-
-	if err != nil {
-		log.Fatal("Start pool monitor cron task err: ", err)
-		return
+		remark = "获取矿池状态成功"
 	}
-
-	c.Start()
-	log.Info("Start pool monitor cron task success!")
+	wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+	return result
 }
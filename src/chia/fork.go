@@ -0,0 +1,203 @@
+package chia
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+	"chia_monitor/src/utils"
+	"chia_monitor/src/wechat"
+)
+
+// BlockRecordsRequest 获取区块记录请求，不包含end
+type BlockRecordsRequest struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// HeightRequest 按高度获取区块记录请求
+type HeightRequest struct {
+	Height int `json:"height"`
+}
+
+// BlockRecordsRpcResult 获取区块记录返回，只解析高度和header hash
+type BlockRecordsRpcResult struct {
+	BlockRecords []struct {
+		HeaderHash string `json:"header_hash"`
+		Height     int    `json:"height"`
+	} `json:"block_records"`
+	Error   string `json:"error"`
+	Success bool   `json:"success"`
+}
+
+// GetBlockRecords 获取[start, end)高度的区块记录
+func (b BlockChain) GetBlockRecords(start, end int) (blockRecordsRpcResult BlockRecordsRpcResult, err error) {
+	url := b.BaseUrl + "get_block_records"
+	blockRecordsRequest := BlockRecordsRequest{Start: start, End: end}
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(b.ctx), url, blockRecordsRequest, "application/json", b.CertPath, b.KeyPath)
+	if err != nil {
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &blockRecordsRpcResult)
+
+	return blockRecordsRpcResult, err
+}
+
+// GetBlockRecordByHeight 按高度获取区块记录
+func (b BlockChain) GetBlockRecordByHeight(height int) (blockRecordRpcResult BlockRecordRpcResult, err error) {
+	url := b.BaseUrl + "get_block_record_by_height"
+	heightRequest := HeightRequest{Height: height}
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(b.ctx), url, heightRequest, "application/json", b.CertPath, b.KeyPath)
+	if err != nil {
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &blockRecordRpcResult)
+
+	return blockRecordRpcResult, err
+}
+
+// forkDetector 记录最近区块的header hash，检测链重组及与参考节点的分叉
+type forkDetector struct {
+	peakHeight      int            //上次检查时的最新区块高度
+	headerHashes    map[int]string //最近区块高度对应的header hash
+	divergenceCount int            //与参考节点连续不一致的次数
+}
+
+// check 检测链重组以及与参考节点的分叉
+func (f *forkDetector) check(blockChain BlockChain, blockchainStateRpcResult BlockchainStateRpcResult) {
+	peak := blockchainStateRpcResult.BlockchainState.Peak
+	if peak.HeaderHash == "" {
+		return
+	}
+	if err := f.checkReorg(blockChain, peak.Height); err != nil {
+		log.Error("Check chain reorg err: ", err)
+	}
+
+	//获取配置文件
+	cfg := config.GetConfig()
+	if cfg.ForkDetection.ReferenceRpcUrl != "" {
+		if err := f.checkReference(blockChain, peak.Height); err != nil {
+			log.Error("Compare with reference node err: ", err)
+		}
+	}
+}
+
+// checkReorg 获取最近的区块记录，与上次记录的header hash比较，计算重组深度
+func (f *forkDetector) checkReorg(blockChain BlockChain, peakHeight int) error {
+	//获取配置文件
+	cfg := config.GetConfig()
+	machineName := cfg.Monitor.MachineName
+	event := "链重组监控"
+
+	start := peakHeight - cfg.ForkDetection.HistoryBlocks + 1
+	if start < 0 {
+		start = 0
+	}
+	blockRecordsRpcResult, err := blockChain.GetBlockRecords(start, peakHeight+1)
+	if err != nil {
+		return err
+	}
+	if !blockRecordsRpcResult.Success {
+		return errors.Errorf("get block records failed: %s", blockRecordsRpcResult.Error)
+	}
+	headerHashes := make(map[int]string)
+	for _, blockRecord := range blockRecordsRpcResult.BlockRecords {
+		headerHashes[blockRecord.Height] = blockRecord.HeaderHash
+	}
+
+	//找出header hash发生变化的最低高度
+	forkHeight := -1
+	for height, headerHash := range f.headerHashes {
+		newHeaderHash, ok := headerHashes[height]
+		if ok && newHeaderHash != headerHash && (forkHeight < 0 || height < forkHeight) {
+			forkHeight = height
+		}
+	}
+	if forkHeight >= 0 {
+		depth := f.peakHeight - forkHeight + 1
+		log.Warnf("Chain reorg detected at height %d, depth: %d", forkHeight, depth)
+		if depth >= cfg.ForkDetection.ReorgAlertDepth {
+			detail := fmt.Sprintf("高度%d发生链重组，重组深度：%d，原最新高度：%d，当前最新高度：%d", forkHeight, depth, f.peakHeight, peakHeight)
+			if depth >= cfg.ForkDetection.ForkDepth {
+				wechat.SendCriticalNoticeToWechat(machineName, event, detail, "链重组过深，节点可能处于分叉链上")
+			} else {
+				wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, "发生链重组")
+			}
+		}
+	}
+
+	f.peakHeight = peakHeight
+	f.headerHashes = headerHashes
+	return nil
+}
+
+// checkReference 比较本节点与参考节点在同一高度的header hash
+func (f *forkDetector) checkReference(blockChain BlockChain, peakHeight int) error {
+	//获取配置文件
+	cfg := config.GetConfig()
+	machineName := cfg.Monitor.MachineName
+	event := "分叉监控"
+
+	//参考节点与本节点使用相同的ctx，退出时一起取消
+	reference := BlockChain{
+		BaseUrl:  cfg.ForkDetection.ReferenceRpcUrl,
+		CertPath: cfg.ForkDetection.ReferenceCertPath,
+		KeyPath:  cfg.ForkDetection.ReferenceKeyPath,
+	}.WithContext(blockChain.ctx)
+	referenceState, err := reference.GetBlockchainState()
+	if err != nil {
+		return err
+	}
+	if !referenceState.Success {
+		return errors.Errorf("get reference blockchain state failed: %s", referenceState.Error)
+	}
+
+	//比较双方都已确认的高度
+	height := peakHeight
+	if referenceState.BlockchainState.Peak.Height < height {
+		height = referenceState.BlockchainState.Peak.Height
+	}
+	height = height - cfg.ForkDetection.ConfirmBlocks
+	if height < 0 {
+		return nil
+	}
+	ours, err := blockChain.GetBlockRecordByHeight(height)
+	if err != nil {
+		return err
+	}
+	theirs, err := reference.GetBlockRecordByHeight(height)
+	if err != nil {
+		return err
+	}
+	if !ours.Success || !theirs.Success {
+		return errors.Errorf("get block record of height %d failed: %s%s", height, ours.Error, theirs.Error)
+	}
+
+	if ours.BlockRecord.HeaderHash == theirs.BlockRecord.HeaderHash {
+		if f.divergenceCount >= cfg.ForkDetection.DivergenceCount {
+			detail := fmt.Sprintf("高度%d的区块与参考节点一致", height)
+			wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, "已恢复到主链")
+		}
+		f.divergenceCount = 0
+		return nil
+	}
+
+	f.divergenceCount = f.divergenceCount + 1
+	log.Warnf("Block of height %d diverges from reference node, count: %d", height, f.divergenceCount)
+	if f.divergenceCount == cfg.ForkDetection.DivergenceCount {
+		detail := fmt.Sprintf("高度%d的区块与参考节点不一致，本节点：%s，参考节点：%s，本节点最新高度：%d，参考节点最新高度：%d，已连续%d次不一致",
+			height, ours.BlockRecord.HeaderHash, theirs.BlockRecord.HeaderHash,
+			peakHeight, referenceState.BlockchainState.Peak.Height, f.divergenceCount)
+		wechat.SendCriticalNoticeToWechat(machineName, event, detail, "节点可能处于分叉链上")
+	}
+	return nil
+}
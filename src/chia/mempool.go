@@ -0,0 +1,381 @@
+package chia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+	"chia_monitor/src/mojo"
+	"chia_monitor/src/monitor"
+	"chia_monitor/src/utils"
+	"chia_monitor/src/wechat"
+)
+
+// feeEstimateSpendType 手续费预估使用的交易类型：普通XCH转账
+const feeEstimateSpendType = "send_xch_transaction"
+
+// MempoolTxIdsRpcResult 获取内存池所有交易id返回
+type MempoolTxIdsRpcResult struct {
+	TxIds   []string `json:"tx_ids"`
+	Error   string   `json:"error"`
+	Success bool     `json:"success"`
+}
+
+// FeeEstimateRequest 手续费预估请求
+type FeeEstimateRequest struct {
+	SpendType   string `json:"spend_type"`
+	SpendCount  int    `json:"spend_count"`
+	TargetTimes []int  `json:"target_times"`
+}
+
+// FeeEstimateRpcResult 手续费预估返回
+type FeeEstimateRpcResult struct {
+	Estimates      []mojo.Amount `json:"estimates"`
+	TargetTimes    []int         `json:"target_times"`
+	CurrentFeeRate float64       `json:"current_fee_rate"`
+	MempoolSize    int64         `json:"mempool_size"`
+	MempoolMaxSize int64         `json:"mempool_max_size"`
+	Error          string        `json:"error"`
+	Success        bool          `json:"success"`
+}
+
+// MempoolStatus 内存池状态
+type MempoolStatus struct {
+	TxCount      int   //内存池交易数
+	Cost         int64 //内存池总cost
+	MaxTotalCost int64 //内存池最大cost
+}
+
+// mempoolTrendWindow 计算内存池趋势时比较的时间跨度
+const mempoolTrendWindow = time.Hour
+
+// mempoolSample 一次检查时的内存池状态
+type mempoolSample struct {
+	Time   time.Time
+	Status MempoolStatus
+}
+
+// mempoolHistory 保留时长内的内存池状态，按检查时间排序
+type mempoolHistory struct {
+	mu      sync.Mutex
+	samples []mempoolSample
+}
+
+// MempoolStats 内存池在保留时长内的峰值及最近1小时的趋势
+type MempoolStats struct {
+	Since           time.Time //最早一次记录的时间
+	PeakTxCount     int       //交易数峰值
+	PeakCostRatio   float64   //cost占比峰值
+	HasTrend        bool      //最近1小时内是否有之前的记录，没有时不计算趋势
+	TxCountChange   int       //最近1小时交易数变化
+	CostRatioChange float64   //最近1小时cost占比变化
+}
+
+// GetAllMempoolTxIds 获取内存池所有交易id
+func (b BlockChain) GetAllMempoolTxIds() (mempoolTxIdsRpcResult MempoolTxIdsRpcResult, err error) {
+	url := b.BaseUrl + "get_all_mempool_tx_ids"
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(b.ctx), url, struct{}{}, "application/json", b.CertPath, b.KeyPath)
+	if err != nil {
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &mempoolTxIdsRpcResult)
+
+	return mempoolTxIdsRpcResult, err
+}
+
+// GetFeeEstimate 预估普通转账在目标时间内确认需要的手续费
+func (b BlockChain) GetFeeEstimate(targetTimes []int) (feeEstimateRpcResult FeeEstimateRpcResult, err error) {
+	url := b.BaseUrl + "get_fee_estimate"
+	feeEstimateRequest := FeeEstimateRequest{
+		SpendType:   feeEstimateSpendType,
+		SpendCount:  1,
+		TargetTimes: targetTimes,
+	}
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(b.ctx), url, feeEstimateRequest, "application/json", b.CertPath, b.KeyPath)
+	if err != nil {
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &feeEstimateRpcResult)
+
+	return feeEstimateRpcResult, err
+}
+
+// GetMempoolStatus 获取内存池交易数及cost
+func (b BlockChain) GetMempoolStatus() (status MempoolStatus, err error) {
+	blockchainStateRpcResult, err := b.GetBlockchainState()
+	if err != nil {
+		return
+	}
+	if !blockchainStateRpcResult.Success {
+		return status, errors.Errorf("get blockchain state failed: %s", blockchainStateRpcResult.Error)
+	}
+	status.TxCount = blockchainStateRpcResult.BlockchainState.MempoolSize
+	status.Cost = blockchainStateRpcResult.BlockchainState.MempoolCost
+	status.MaxTotalCost = blockchainStateRpcResult.BlockchainState.MempoolMaxTotalCost
+
+	//旧版本区块链状态中没有内存池交易数时，从交易id列表统计
+	if status.TxCount == 0 {
+		mempoolTxIdsRpcResult, err := b.GetAllMempoolTxIds()
+		if err != nil {
+			return status, err
+		}
+		if !mempoolTxIdsRpcResult.Success {
+			return status, errors.Errorf("get all mempool tx ids failed: %s", mempoolTxIdsRpcResult.Error)
+		}
+		status.TxCount = len(mempoolTxIdsRpcResult.TxIds)
+	}
+	return status, nil
+}
+
+// CostRatio 内存池cost占最大cost的比例，未知时返回0
+func (s MempoolStatus) CostRatio() float64 {
+	if s.MaxTotalCost <= 0 {
+		return 0
+	}
+	return float64(s.Cost) / float64(s.MaxTotalCost)
+}
+
+// IsCongested 是否拥堵
+func (s MempoolStatus) IsCongested() bool {
+	//获取配置文件
+	cfg := config.GetConfig()
+	if s.CostRatio() >= cfg.MempoolMonitor.CongestionRatio {
+		return true
+	}
+	return cfg.MempoolMonitor.MaxTxCount > 0 && s.TxCount >= cfg.MempoolMonitor.MaxTxCount
+}
+
+// String 内存池状态描述
+func (s MempoolStatus) String() string {
+	if s.MaxTotalCost <= 0 {
+		return fmt.Sprintf("内存池交易数：%d", s.TxCount)
+	}
+	return fmt.Sprintf("内存池交易数：%d，内存池cost：%d/%d（%.1f%%）", s.TxCount, s.Cost, s.MaxTotalCost, s.CostRatio()*100)
+}
+
+// recommendedFee 推荐手续费描述
+func recommendedFee(blockChain BlockChain) string {
+	//获取配置文件
+	cfg := config.GetConfig()
+	feeEstimateRpcResult, err := blockChain.GetFeeEstimate(cfg.MempoolMonitor.TargetTimes)
+	if err != nil {
+		log.Error("Get fee estimate err: ", err)
+		return fmt.Sprintf("获取推荐手续费错误：%s", err)
+	}
+	if !feeEstimateRpcResult.Success {
+		log.Error("Get fee estimate rpc result failed: ", feeEstimateRpcResult.Error)
+		return fmt.Sprintf("获取推荐手续费失败：%s", feeEstimateRpcResult.Error)
+	}
+	var fees []string
+	for i, estimate := range feeEstimateRpcResult.Estimates {
+		if i >= len(feeEstimateRpcResult.TargetTimes) {
+			break
+		}
+		fees = append(fees, fmt.Sprintf("%s内确认 %s XCH",
+			formatDuration(time.Duration(feeEstimateRpcResult.TargetTimes[i])*time.Second), estimate))
+	}
+	return "推荐手续费：" + strings.Join(fees, "，")
+}
+
+// add 记录一次内存池状态，并清理超过保留时长的记录
+func (h *mempoolHistory) add(now time.Time, status MempoolStatus, keep time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples = append(h.samples, mempoolSample{Time: now, Status: status})
+	expired := 0
+	for expired < len(h.samples) && now.Sub(h.samples[expired].Time) > keep {
+		expired++
+	}
+	h.samples = h.samples[expired:]
+}
+
+// stats 统计保留时长内的记录及当前状态，current为本次检查的状态，尚未记录
+func (h *mempoolHistory) stats(now time.Time, current MempoolStatus, keep time.Duration) MempoolStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := MempoolStats{Since: now, PeakTxCount: current.TxCount, PeakCostRatio: current.CostRatio()}
+	for _, sample := range h.samples {
+		if now.Sub(sample.Time) > keep {
+			continue
+		}
+		if sample.Time.Before(stats.Since) {
+			stats.Since = sample.Time
+		}
+		if sample.Status.TxCount > stats.PeakTxCount {
+			stats.PeakTxCount = sample.Status.TxCount
+		}
+		if sample.Status.CostRatio() > stats.PeakCostRatio {
+			stats.PeakCostRatio = sample.Status.CostRatio()
+		}
+		//与最近1小时内最早的记录比较
+		if !stats.HasTrend && now.Sub(sample.Time) <= mempoolTrendWindow {
+			stats.HasTrend = true
+			stats.TxCountChange = current.TxCount - sample.Status.TxCount
+			stats.CostRatioChange = current.CostRatio() - sample.Status.CostRatio()
+		}
+	}
+	return stats
+}
+
+// Trend 趋势描述：上升、下降或持平，节点未返回最大cost时按交易数判断
+func (s MempoolStats) Trend(current MempoolStatus) string {
+	change := float64(s.TxCountChange)
+	threshold := 0.0
+	if current.MaxTotalCost > 0 {
+		change = s.CostRatioChange
+		threshold = 0.01
+	}
+	if change > threshold {
+		return "上升"
+	}
+	if change < -threshold {
+		return "下降"
+	}
+	return "持平"
+}
+
+// String 峰值及趋势描述
+func (s MempoolStats) String(now time.Time, current MempoolStatus) string {
+	text := fmt.Sprintf("近%s峰值：交易数 %d", formatDuration(now.Sub(s.Since)), s.PeakTxCount)
+	if current.MaxTotalCost > 0 {
+		text = text + fmt.Sprintf("，cost %.1f%%", s.PeakCostRatio*100)
+	}
+	if !s.HasTrend {
+		return text
+	}
+	text = text + fmt.Sprintf("\n近1小时趋势：%s，交易数 %+d", s.Trend(current), s.TxCountChange)
+	if current.MaxTotalCost > 0 {
+		text = text + fmt.Sprintf("，cost %+.1f%%", s.CostRatioChange*100)
+	}
+	return text
+}
+
+// MempoolMonitor 内存池监控，持续拥堵时通知
+type MempoolMonitor struct {
+	blockChain     BlockChain
+	mu             sync.Mutex //保护congestedCount，防止重新调度时两次Run并发执行
+	congestedCount int
+	history        mempoolHistory //Run检查时记录，钱包日报及Check也会读取
+}
+
+// NewMempoolMonitor 创建内存池监控
+func NewMempoolMonitor(blockChain BlockChain) *MempoolMonitor {
+	return &MempoolMonitor{blockChain: blockChain}
+}
+
+// Name 监控名称
+func (m *MempoolMonitor) Name() string {
+	return "mempool"
+}
+
+// Schedule 默认调度：每隔mempoolMonitor.interval分钟检查
+func (m *MempoolMonitor) Schedule() monitor.Schedule {
+	return monitor.Every(config.GetConfig().MempoolMonitor.Interval)
+}
+
+// Check 检查内存池是否拥堵
+func (m *MempoolMonitor) Check(ctx context.Context) monitor.Result {
+	_, result := m.check(m.blockChain.WithContext(ctx), time.Now())
+	return result
+}
+
+// check 获取内存池状态并生成检查结果，获取失败时result.Err不为空
+func (m *MempoolMonitor) check(blockChain BlockChain, now time.Time) (MempoolStatus, monitor.Result) {
+	status, err := blockChain.GetMempoolStatus()
+	if err != nil {
+		return status, monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "获取内存池状态错误", err)
+	}
+	return status, m.result(status, now)
+}
+
+// historyKeep 内存池状态保留时长
+func historyKeep() time.Duration {
+	return time.Duration(config.GetConfig().MempoolMonitor.HistoryHours) * time.Hour
+}
+
+// result 根据内存池状态及历史记录生成检查结果
+func (m *MempoolMonitor) result(status MempoolStatus, now time.Time) monitor.Result {
+	stats := m.history.stats(now, status, historyKeep())
+	result := monitor.NewResult(m.Name(), monitor.StatusOK, status.String())
+	result.Detail = status.String() + "\n" + stats.String(now, status)
+	if status.IsCongested() {
+		result.Status = monitor.StatusWarning
+		result.Summary = "内存池拥堵，" + status.String()
+	}
+	result.Metrics["mempool_tx_count"] = float64(status.TxCount)
+	result.Metrics["mempool_cost_ratio"] = status.CostRatio()
+	result.Metrics["mempool_peak_tx_count"] = float64(stats.PeakTxCount)
+	result.Metrics["mempool_peak_cost_ratio"] = stats.PeakCostRatio
+	if stats.HasTrend {
+		result.Metrics["mempool_tx_count_change_1h"] = float64(stats.TxCountChange)
+		result.Metrics["mempool_cost_ratio_change_1h"] = stats.CostRatioChange
+	}
+	return result
+}
+
+// Report 内存池状态、峰值、趋势及推荐手续费报告，附加在钱包日报中
+func (m *MempoolMonitor) Report(blockChain BlockChain) string {
+	status, err := blockChain.GetMempoolStatus()
+	if err != nil {
+		log.Error("Get mempool status err: ", err)
+		return fmt.Sprintf("获取内存池状态错误：%s\n%s", err, recommendedFee(blockChain))
+	}
+	now := time.Now()
+	stats := m.history.stats(now, status, historyKeep())
+	return status.String() + "\n" + stats.String(now, status) + "\n" + recommendedFee(blockChain)
+}
+
+// Run 检查内存池并记录状态，连续拥堵SustainedCount次时通知，缓解后发送恢复通知
+func (m *MempoolMonitor) Run(ctx context.Context) monitor.Result {
+	var event string
+	var detail string
+	var remark string
+
+	//获取配置文件
+	cfg := config.GetConfig()
+	machineName := cfg.Monitor.MachineName
+	event = "内存池监控"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blockChain := m.blockChain.WithContext(ctx)
+
+	now := time.Now()
+	status, result := m.check(blockChain, now)
+	if result.Err != nil {
+		log.Errorf("%s: %s", result.Summary, result.Err)
+		return result
+	}
+	m.history.add(now, status, historyKeep())
+	if status.IsCongested() {
+		m.congestedCount = m.congestedCount + 1
+		log.Warnf("Mempool is congested, count: %d, status: %+v", m.congestedCount, status)
+		if m.congestedCount == cfg.MempoolMonitor.SustainedCount {
+			detail = result.Detail + "\n" + recommendedFee(blockChain)
+			remark = fmt.Sprintf("内存池已连续%d次检查拥堵，转账请提高手续费", m.congestedCount)
+			wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+		}
+		return result
+	}
+	log.Debugf("Mempool status: %+v", status)
+	if m.congestedCount >= cfg.MempoolMonitor.SustainedCount {
+		detail = result.Detail
+		remark = "内存池拥堵已缓解"
+		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+	}
+	m.congestedCount = 0
+	return result
+}
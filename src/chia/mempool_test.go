@@ -0,0 +1,49 @@
+package chia
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMempoolHistory(t *testing.T) {
+	var history mempoolHistory
+	keep := 24 * time.Hour
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.Local)
+	status := func(txCount int, cost int64) MempoolStatus {
+		return MempoolStatus{TxCount: txCount, Cost: cost, MaxTotalCost: 100}
+	}
+
+	//没有记录时只统计当前状态，不计算趋势
+	stats := history.stats(now, status(10, 20), keep)
+	if stats.PeakTxCount != 10 || stats.PeakCostRatio != 0.2 || stats.HasTrend {
+		t.Errorf("stats without history = %+v, want peak of current status", stats)
+	}
+
+	history.add(now.Add(-25*time.Hour), status(500, 99), keep)
+	history.add(now.Add(-3*time.Hour), status(300, 90), keep)
+	history.add(now.Add(-50*time.Minute), status(50, 40), keep)
+	history.add(now.Add(-10*time.Minute), status(80, 60), keep)
+	//超过保留时长的记录被清理
+	if len(history.samples) != 3 {
+		t.Fatalf("samples = %d, want 3", len(history.samples))
+	}
+
+	current := status(20, 30)
+	stats = history.stats(now, current, keep)
+	if stats.PeakTxCount != 300 || stats.PeakCostRatio != 0.9 {
+		t.Errorf("peak = %d, %v, want 300, 0.9", stats.PeakTxCount, stats.PeakCostRatio)
+	}
+	if !stats.Since.Equal(now.Add(-3 * time.Hour)) {
+		t.Errorf("since = %v, want 3 hours ago", stats.Since)
+	}
+	//与最近1小时内最早的记录比较
+	if !stats.HasTrend || stats.TxCountChange != -30 || stats.Trend(current) != "下降" {
+		t.Errorf("trend = %+v, %s, want falling by 30 transactions", stats, stats.Trend(current))
+	}
+
+	//旧版本节点没有最大cost时按交易数判断
+	old := MempoolStatus{TxCount: 60}
+	if trend := history.stats(now, old, keep).Trend(old); trend != "上升" {
+		t.Errorf("trend without max cost = %s, want 上升", trend)
+	}
+}
@@ -1,15 +1,22 @@
 package chia
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/robfig/cron"
 	log "github.com/sirupsen/logrus"
 
 	"chia_monitor/src/config"
+	"chia_monitor/src/ledger"
+	"chia_monitor/src/mojo"
+	"chia_monitor/src/monitor"
+	"chia_monitor/src/price"
 	"chia_monitor/src/utils"
 	"chia_monitor/src/wechat"
 )
@@ -17,14 +24,17 @@ import (
 const XCHPoolDailyEarningUrl = "https://farmer.xchpool.io/api/xchpool/farmer/earnings/daily"
 const DPoolRewardUrl = "https://vip.dpool.cc:9999/queryRewardRecord"
 
+//收益账本
+var earningLedger *ledger.Ledger
+
 //XCHPoolEarning XCHPool收益
 type XCHPoolEarning struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	Code    int    `json:"code"`
 	Result  []struct {
-		Date   string  `json:"date"`
-		Amount float64 `json:"amount"`
+		Date   string      `json:"date"`
+		Amount json.Number `json:"amount"`
 	} `json:"result"`
 	Timestamp int64 `json:"timestamp"`
 }
@@ -54,7 +64,7 @@ type DpoolRewardRecord struct {
 }
 
 // getXCHPoolEarning 获取XCHPool收益
-func getXCHPoolEarning() (xchPoolEarning XCHPoolEarning, err error) {
+func getXCHPoolEarning(ctx context.Context) (xchPoolEarning XCHPoolEarning, err error) {
 	params := url.Values{}
 	Url, err := url.Parse(XCHPoolDailyEarningUrl)
 	if err != nil {
@@ -69,7 +79,7 @@ func getXCHPoolEarning() (xchPoolEarning XCHPoolEarning, err error) {
 	Url.RawQuery = params.Encode()
 	urlPath := Url.String()
 	log.Info("XCHPool earning url: ", urlPath)
-	resp, err := utils.Get(urlPath)
+	resp, err := utils.GetContext(ctx, urlPath)
 	if err != nil {
 		return
 	}
@@ -79,7 +89,7 @@ func getXCHPoolEarning() (xchPoolEarning XCHPoolEarning, err error) {
 }
 
 // getDpoolRewardRecord 获取电池收益
-func getDpoolRewardRecord() (dpoolRewardRecord DpoolRewardRecord, err error) {
+func getDpoolRewardRecord(ctx context.Context) (dpoolRewardRecord DpoolRewardRecord, err error) {
 	//获取配置文件
 	cfg := config.GetConfig()
 
@@ -89,7 +99,7 @@ func getDpoolRewardRecord() (dpoolRewardRecord DpoolRewardRecord, err error) {
 		Days:       30,
 	}
 	log.Infof("dpoolRewardRequest: %+v", dpoolRewardRequest)
-	resp, err := utils.Post(DPoolRewardUrl, dpoolRewardRequest, "application/json")
+	resp, err := utils.PostContext(ctx, DPoolRewardUrl, dpoolRewardRequest, "application/json")
 	if err != nil {
 		log.Errorf("Get Dpool reward failed: %+v", err)
 		return
@@ -106,72 +116,269 @@ func getDpoolRewardRecord() (dpoolRewardRecord DpoolRewardRecord, err error) {
 	return dpoolRewardRecord, err
 }
 
-//MonitorPoolEarning 监控矿池收益
-func MonitorPoolEarning(poolName string) {
-	var event string
-	var detail string
-	var remark string
+// PoolEarningMonitor 矿池收益监控，每日发送收益并写入收益账本，未专门适配的矿池使用官方矿池协议
+type PoolEarningMonitor struct {
+	poolName string
+	farmer   Farmer
+}
+
+// NewPoolEarningMonitor 创建矿池收益监控，打开收益账本
+func NewPoolEarningMonitor(poolName string, farmer Farmer) *PoolEarningMonitor {
+	openEarningLedger()
+	return &PoolEarningMonitor{poolName: poolName, farmer: farmer}
+}
+
+// Name 监控名称
+func (m *PoolEarningMonitor) Name() string {
+	return "poolEarning"
+}
+
+// Schedule 默认调度：按dailyCron每日发送
+func (m *PoolEarningMonitor) Schedule() monitor.Schedule {
+	return monitor.Cron(config.GetConfig().Monitor.DailyCron)
+}
+
+// Check 统计收益账本中的近期收益
+func (m *PoolEarningMonitor) Check(ctx context.Context) monitor.Result {
+	if earningLedger == nil {
+		return monitor.NewResult(m.Name(), monitor.StatusUnknown, "收益账本未打开")
+	}
+	//获取配置文件
+	cfg := config.GetConfig()
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	weekTotal := earningLedger.Total(m.poolName, now.AddDate(0, 0, -6), now)
+	monthTotal := earningLedger.Total(m.poolName, now.AddDate(0, 0, -29), now)
+	result := monitor.NewResult(m.Name(), monitor.StatusOK,
+		fmt.Sprintf("%s，近7日收益：%s，近30日收益：%s", m.poolName, weekTotal.Format(5), monthTotal.Format(5)))
+	result.Detail = result.Summary
+	missingDays := earningLedger.MissingDays(m.poolName, yesterday.AddDate(0, 0, 1-cfg.LedgerConfig.MissingDays), yesterday)
+	if len(missingDays) > 0 {
+		result.Status = monitor.StatusWarning
+		result.Detail = result.Detail + fmt.Sprintf("\n缺失收益日期：%s", strings.Join(missingDays, "、"))
+	}
+	result.Metrics["earning_7d"] = weekTotal.XCH()
+	result.Metrics["earning_30d"] = monthTotal.XCH()
+	result.Metrics["earning_missing_days"] = float64(len(missingDays))
+	return result
+}
+
+// Run 获取矿池收益并记入账本，发送通知，再按账本统计近期收益
+func (m *PoolEarningMonitor) Run(ctx context.Context) monitor.Result {
 	//获取配置文件
 	cfg := config.GetConfig()
-	machineName := cfg.Monitor.MachineName
-
-	event = "获取矿池收益"
-
-	//创建定时任务
-	c := cron.New()
-	err := c.AddFunc(cfg.Monitor.DailyCron, func() {
-		//获取矿池收益
-		switch poolName {
-		case "XCHPool":
-			xchPoolEarning, err := getXCHPoolEarning()
-			log.Infof("xchPoolEarning: %+v", xchPoolEarning)
-			if err != nil {
-				detail = fmt.Sprintf("获取XCHPool收益错误：%s", err.Error())
-				remark = "获取矿池收益错误"
-			} else if !xchPoolEarning.Success {
-				detail = fmt.Sprintf("获取XCHPool收益失败：%s", xchPoolEarning.Message)
-				remark = "获取矿池收益失败"
-			} else {
-				yesterdayEarning := xchPoolEarning.Result[len(xchPoolEarning.Result)-2].Amount
-				todayEarning := xchPoolEarning.Result[len(xchPoolEarning.Result)-1].Amount
-				detail = fmt.Sprintf("%s，昨日收益：%.5f，今日当前收益：%.5f", poolName, yesterdayEarning, todayEarning)
-				remark = "获取矿池收益成功"
+	status, detail, remark := m.fetchEarnings(ctx)
+	//发送微信消息成功
+	wechat.SendChiaMonitorNoticeToWechat(cfg.Monitor.MachineName, "获取矿池收益", detail, remark)
+
+	if earningLedger == nil {
+		result := monitor.NewResult(m.Name(), status, remark)
+		result.Detail = detail
+		return result
+	}
+	result := m.Check(ctx)
+	result.Status = monitor.Worse(result.Status, status)
+	//获取成功时收益描述中已包含账本统计，获取失败时附加账本中已有的统计
+	if status != monitor.StatusOK {
+		detail = detail + "\n" + result.Detail
+	}
+	result.Detail = detail
+	return result
+}
+
+// fetchEarnings 获取矿池收益并记入账本，返回状态、收益描述及备注，获取失败时为Unknown
+func (m *PoolEarningMonitor) fetchEarnings(ctx context.Context) (status monitor.Status, detail, remark string) {
+	status = monitor.StatusUnknown
+	//获取矿池收益
+	switch m.poolName {
+	case "XCHPool":
+		xchPoolEarning, err := getXCHPoolEarning(ctx)
+		log.Infof("xchPoolEarning: %+v", xchPoolEarning)
+		if err != nil {
+			detail = fmt.Sprintf("获取XCHPool收益错误：%s", err.Error())
+			remark = "获取矿池收益错误"
+		} else if !xchPoolEarning.Success {
+			detail = fmt.Sprintf("获取XCHPool收益失败：%s", xchPoolEarning.Message)
+			remark = "获取矿池收益失败"
+		} else {
+			var yesterdayEarning, todayEarning mojo.Amount
+			if len(xchPoolEarning.Result) > 1 {
+				yesterdayEarning, _ = mojo.ParseXCH(xchPoolEarning.Result[len(xchPoolEarning.Result)-2].Amount.String())
+			}
+			if len(xchPoolEarning.Result) > 0 {
+				todayEarning, _ = mojo.ParseXCH(xchPoolEarning.Result[len(xchPoolEarning.Result)-1].Amount.String())
+			}
+			detail = fmt.Sprintf("%s，昨日收益：%s%s，今日当前收益：%s%s", m.poolName,
+				yesterdayEarning.Format(5), fiatSuffix(ctx, yesterdayEarning),
+				todayEarning.Format(5), fiatSuffix(ctx, todayEarning))
+			detail = detail + recordEarnings(ctx, m.poolName, xchPoolEarningRecords(xchPoolEarning))
+			remark = "获取矿池收益成功"
+			status = monitor.StatusOK
+		}
+	case "Dpool":
+		var yesterdayReward, todayReward mojo.Amount
+		dpoolRewardRecord, err := getDpoolRewardRecord(ctx)
+		if err != nil {
+			detail = fmt.Sprintf("获取Dpool收益错误：%s", err.Error())
+			remark = "获取矿池收益错误"
+		} else if dpoolRewardRecord.Code != 0 {
+			detail = fmt.Sprintf("获取Dpool收益失败：%s", dpoolRewardRecord.Message)
+			remark = "获取矿池收益失败"
+		} else {
+			if len(dpoolRewardRecord.Data) > 1 {
+				yesterdayReward, _ = mojo.Parse(dpoolRewardRecord.Data[1].Amount)
 			}
-		case "Dpool":
-			var yesterdayReward string
-			dpoolRewardRecord, err := getDpoolRewardRecord()
-			if err != nil {
-				detail = fmt.Sprintf("获取Dpool收益错误：%s", err.Error())
-				remark = "获取矿池收益错误"
-			} else if dpoolRewardRecord.Code != 0 {
-				detail = fmt.Sprintf("获取XCHPool收益失败：%s", dpoolRewardRecord.Message)
-				remark = "获取矿池收益失败"
-			} else {
-				if len(dpoolRewardRecord.Data) > 1{
-					yesterdayReward = dpoolRewardRecord.Data[1].Amount
-				}
-				todayReward :=  dpoolRewardRecord.Data[0].Amount
-				yesterdayRewardInt, _ := strconv.Atoi(yesterdayReward)
-				yesterdayRewardFloat, _ := strconv.ParseFloat(fmt.Sprintf("%.12f", float64(yesterdayRewardInt)/float64(1000000000000)), 64)
-				todayRewardInt, _ := strconv.Atoi(todayReward)
-				todayRewardFloat, _ := strconv.ParseFloat(fmt.Sprintf("%.12f", float64(todayRewardInt)/float64(1000000000000)), 64)
-				detail = fmt.Sprintf("%s，昨日收益：%.5f，今日当前收益：%.5f", poolName, yesterdayRewardFloat, todayRewardFloat)
-				remark = "获取矿池收益成功"
+			if len(dpoolRewardRecord.Data) > 0 {
+				todayReward, _ = mojo.Parse(dpoolRewardRecord.Data[0].Amount)
 			}
-		default:
-			log.Error("Unknown pool name: ", poolName)
-			detail = fmt.Sprintf("未知的矿池名称：%s", poolName)
+			detail = fmt.Sprintf("%s，昨日收益：%s%s，今日当前收益：%s%s", m.poolName,
+				yesterdayReward.Format(5), fiatSuffix(ctx, yesterdayReward),
+				todayReward.Format(5), fiatSuffix(ctx, todayReward))
+			detail = detail + recordEarnings(ctx, m.poolName, dpoolRewardRecords(dpoolRewardRecord))
+			remark = "获取矿池收益成功"
+			status = monitor.StatusOK
+		}
+	default:
+		//没有专门适配的矿池，使用官方矿池协议获取农民信息
+		log.Infof("Pool %s has no bespoke integration, use official pool protocol", m.poolName)
+		poolStateRpcResult, err := m.farmer.WithContext(ctx).GetPoolState()
+		if err != nil {
+			detail = fmt.Sprintf("获取矿池状态错误：%s", err.Error())
 			remark = "获取矿池收益错误"
+		} else if !poolStateRpcResult.Success {
+			detail = fmt.Sprintf("获取矿池状态失败：%s", poolStateRpcResult.Error)
+			remark = "获取矿池收益失败"
+		} else if poolProtocolDetail := getPoolProtocolDetail(ctx, poolStateRpcResult); poolProtocolDetail == "" {
+			detail = "未加入任何矿池"
+			remark = "获取矿池收益失败"
+		} else {
+			detail = poolProtocolDetail
+			remark = "获取矿池收益成功"
+			status = monitor.StatusOK
 		}
-		//发送微信消息成功
-		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-	})
+	}
+	return status, detail, remark
+}
 
+// openEarningLedger 打开收益账本，失败时只发送收益通知不记录账本
+func openEarningLedger() {
+	if earningLedger != nil {
+		return
+	}
+	//获取配置文件
+	cfg := config.GetConfig()
+	l, err := ledger.Open(cfg.LedgerConfig.DbPath)
 	if err != nil {
-		log.Fatal("Start pool earning cron task err: ", err)
+		log.Error("Open earning ledger err: ", err)
 		return
 	}
+	earningLedger = l
+	log.Infof("Open earning ledger %s success!", cfg.LedgerConfig.DbPath)
+}
+
+// xchPoolEarningRecords 将XCHPool每日收益转换为账本记录
+func xchPoolEarningRecords(xchPoolEarning XCHPoolEarning) (records []ledger.Record) {
+	for _, result := range xchPoolEarning.Result {
+		amount, err := mojo.ParseXCH(result.Amount.String())
+		if err != nil {
+			log.Errorf("Parse XCHPool earning amount %s failed: %s", result.Amount, err)
+			continue
+		}
+		records = append(records, ledger.Record{
+			Pool:   "XCHPool",
+			Date:   earningDate(result.Date, 0),
+			Amount: amount,
+		})
+	}
+	return records
+}
+
+// dpoolRewardRecords 将电池支付记录转换为账本记录
+func dpoolRewardRecords(dpoolRewardRecord DpoolRewardRecord) (records []ledger.Record) {
+	for _, data := range dpoolRewardRecord.Data {
+		amount, err := mojo.Parse(data.Amount)
+		if err != nil {
+			log.Errorf("Parse Dpool reward amount %s failed: %s", data.Amount, err)
+			continue
+		}
+		blockIndex, _ := strconv.ParseInt(data.BloackIndex, 10, 64)
+		records = append(records, ledger.Record{
+			Pool:       "Dpool",
+			Date:       earningDate(data.CreateTime, int64(data.Timestamp)),
+			Amount:     amount,
+			CoinId:     data.CoinID,
+			BlockIndex: blockIndex,
+		})
+	}
+	return records
+}
+
+// earningDate 收益日期，优先使用时间戳（秒或毫秒），否则取日期字符串的年月日部分
+func earningDate(date string, timestamp int64) string {
+	if timestamp > 1e12 {
+		timestamp = timestamp / 1000
+	}
+	if timestamp > 0 {
+		return time.Unix(timestamp, 0).Format("2006-01-02")
+	}
+	if len(date) > len("2006-01-02") {
+		date = date[:len("2006-01-02")]
+	}
+	return date
+}
+
+// recordEarnings 写入收益账本，返回近期收益统计
+func recordEarnings(ctx context.Context, poolName string, records []ledger.Record) string {
+	if earningLedger == nil {
+		return ""
+	}
+	//只按当前价格计算当天收益的法币价值，补录的历史收益不计算，已记录的价值在账本中保留
+	today := time.Now().Format("2006-01-02")
+	for i := range records {
+		if records[i].Date == today {
+			records[i].Fiat = price.Values(ctx, records[i].Amount)
+		}
+	}
+	inserted, updated, err := earningLedger.Upsert(records...)
+	if err != nil {
+		log.Error("Record earnings to ledger err: ", err)
+		return fmt.Sprintf("\n写入收益账本失败：%s", err)
+	}
+	log.Infof("Record %s earnings to ledger, inserted: %d, updated: %d", poolName, inserted, updated)
+
+	//获取配置文件
+	cfg := config.GetConfig()
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	weekTotal := earningLedger.Total(poolName, now.AddDate(0, 0, -6), now)
+	monthTotal := earningLedger.Total(poolName, now.AddDate(0, 0, -29), now)
+	summary := fmt.Sprintf("\n近7日收益：%s，近30日收益：%s", weekTotal.Format(5), monthTotal.Format(5))
+	if fiatTotals := earningLedger.FiatTotals(poolName, now.AddDate(0, 0, -29), now); len(fiatTotals) > 0 {
+		var values []string
+		for _, currency := range cfg.PriceConfig.Currencies {
+			if value, ok := fiatTotals[currency]; ok {
+				values = append(values, price.FormatValue(currency, value))
+			}
+		}
+		if len(values) > 0 {
+			summary = summary + fmt.Sprintf("（收益时价值 %s）", strings.Join(values, " / "))
+		}
+	}
+
+	missingDays := earningLedger.MissingDays(poolName, yesterday.AddDate(0, 0, 1-cfg.LedgerConfig.MissingDays), yesterday)
+	if len(missingDays) > 0 {
+		summary = summary + fmt.Sprintf("\n缺失收益日期：%s", strings.Join(missingDays, "、"))
+	}
+
+	poolTotals := earningLedger.ComparePools(now.AddDate(0, 0, -29), now)
+	if len(poolTotals) > 1 {
+		var pools []string
+		for pool, total := range poolTotals {
+			pools = append(pools, fmt.Sprintf("%s %s", pool, total.Format(5)))
+		}
+		sort.Strings(pools)
+		summary = summary + fmt.Sprintf("\n各矿池近30日收益：%s", strings.Join(pools, "，"))
+	}
 
-	c.Start()
-	log.Info("Start pool earning cron task success!")
+	return summary
 }
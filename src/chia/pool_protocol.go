@@ -0,0 +1,120 @@
+package chia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/utils"
+)
+
+// PoolInfo 官方矿池协议 GET /pool_info 返回
+type PoolInfo struct {
+	Name                       string      `json:"name"`
+	LogoUrl                    string      `json:"logo_url"`
+	Description                string      `json:"description"`
+	MinimumDifficulty          int         `json:"minimum_difficulty"`
+	RelativeLockHeight         int         `json:"relative_lock_height"`
+	ProtocolVersion            int         `json:"protocol_version"`
+	Fee                        json.Number `json:"fee"`
+	TargetPuzzleHash           string      `json:"target_puzzle_hash"`
+	AuthenticationTokenTimeout int         `json:"authentication_token_timeout"`
+}
+
+// PoolProtocolError 官方矿池协议错误返回
+type PoolProtocolError struct {
+	ErrorCode    int    `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// PoolProtocol 官方矿池协议适配器，适用于所有实现了Chia矿池协议的矿池。
+// 只调用无需认证的GET /pool_info，GET /farmer需要用农民的认证私钥签名authentication_token，
+// 监控不持有私钥，因此无法获取矿池份额等农民信息，积分、难度及收款地址从农民的get_pool_state获取
+type PoolProtocol struct {
+	PoolUrl string
+	ctx     context.Context //请求使用的ctx，为空时不可取消
+}
+
+// WithContext 返回使用ctx发起请求的副本，ctx取消时中断请求
+func (p PoolProtocol) WithContext(ctx context.Context) PoolProtocol {
+	p.ctx = ctx
+	return p
+}
+
+// NewPoolProtocol 根据get_pool_state返回的PoolConfig.PoolURL创建适配器
+func NewPoolProtocol(poolUrl string) PoolProtocol {
+	return PoolProtocol{PoolUrl: strings.TrimRight(poolUrl, "/")}
+}
+
+// get 发起GET请求，矿池返回error_code时转换为错误
+func (p PoolProtocol) get(path string, result interface{}) error {
+	Url, err := url.Parse(p.PoolUrl + path)
+	if err != nil {
+		return err
+	}
+	log.Debug("Pool protocol url: ", Url.String())
+	resp, err := utils.GetContext(orBackground(p.ctx), Url.String())
+	if err != nil {
+		return err
+	}
+	log.Debug(string(resp))
+
+	var protocolError PoolProtocolError
+	if json.Unmarshal(resp, &protocolError) == nil && protocolError.ErrorCode != 0 {
+		return errors.Errorf("pool error %d: %s", protocolError.ErrorCode, protocolError.ErrorMessage)
+	}
+
+	return json.Unmarshal(resp, result)
+}
+
+// GetPoolInfo 获取矿池信息：手续费、最低难度、协议版本等
+func (p PoolProtocol) GetPoolInfo() (poolInfo PoolInfo, err error) {
+	err = p.get("/pool_info", &poolInfo)
+	return poolInfo, err
+}
+
+// getPoolProtocolDetail 通过官方矿池协议获取每个矿池的信息，农民的积分、难度及24小时确认积分从get_pool_state获取。
+// 协议的GET /farmer需要农民私钥签名的authentication_token，这里不调用，不提供矿池份额
+func getPoolProtocolDetail(ctx context.Context, poolStateRpcResult PoolStateRpcResult) string {
+	var details []string
+
+	for _, poolState := range poolStateRpcResult.PoolState {
+		poolUrl := poolState.PoolConfig.PoolURL
+		if poolUrl == "" {
+			continue
+		}
+		poolProtocol := NewPoolProtocol(poolUrl).WithContext(ctx)
+
+		poolInfo, err := poolProtocol.GetPoolInfo()
+		if err != nil {
+			log.Errorf("Get pool info of %s failed: %s", poolUrl, err)
+			details = append(details, fmt.Sprintf("%s，获取矿池信息失败：%s", poolUrl, err))
+			continue
+		}
+		//points_acknowledged_24h为[时间戳, 积分]的列表
+		var points24H float64
+		for _, point := range poolState.PointsAcknowledged24H {
+			if len(point) > 1 {
+				points24H += point[1]
+			}
+		}
+		detail := fmt.Sprintf("%s，手续费：%s，最低难度：%d，协议版本：%d，矿池积分：%d，24小时确认积分：%.0f，矿池难度：%d，收款地址：%s",
+			poolInfo.Name,
+			poolInfo.Fee,
+			poolInfo.MinimumDifficulty,
+			poolInfo.ProtocolVersion,
+			poolState.CurrentPoints,
+			points24H,
+			poolState.CurrentDifficulty,
+			poolState.PoolConfig.PayoutInstructions,
+		)
+		details = append(details, detail)
+	}
+
+	return strings.Join(details, "\n")
+}
@@ -0,0 +1,104 @@
+package chia
+
+import (
+	"fmt"
+	"time"
+)
+
+// syncProgressSamples 计算同步速度使用的最近采样数量
+const syncProgressSamples = 12
+
+// syncSample 同步进度采样
+type syncSample struct {
+	time   time.Time
+	height int
+}
+
+// syncProgress 记录同步进度，计算同步速度、预计剩余时间以及同步是否停滞
+type syncProgress struct {
+	samples []syncSample
+}
+
+// add 添加一次同步进度采样，stallCount为判定停滞的连续次数，至少保留stallCount+1个采样
+func (p *syncProgress) add(height int, now time.Time, stallCount int) {
+	p.samples = append(p.samples, syncSample{time: now, height: height})
+	keep := syncProgressSamples
+	if stallCount+1 > keep {
+		keep = stallCount + 1
+	}
+	if len(p.samples) > keep {
+		p.samples = p.samples[len(p.samples)-keep:]
+	}
+}
+
+// reset 清空同步进度采样
+func (p *syncProgress) reset() {
+	p.samples = nil
+}
+
+// rate 最近syncProgressSamples个采样的同步速度，单位：区块/分钟
+func (p *syncProgress) rate() float64 {
+	samples := p.samples
+	if len(samples) > syncProgressSamples {
+		samples = samples[len(samples)-syncProgressSamples:]
+	}
+	if len(samples) < 2 {
+		return 0
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	minutes := last.time.Sub(first.time).Minutes()
+	if minutes <= 0 || last.height <= first.height {
+		return 0
+	}
+	return float64(last.height-first.height) / minutes
+}
+
+// eta 同步到tipHeight的预计剩余时间，同步速度未知时返回false
+func (p *syncProgress) eta(tipHeight int) (time.Duration, bool) {
+	rate := p.rate()
+	if rate <= 0 || len(p.samples) == 0 {
+		return 0, false
+	}
+	remaining := tipHeight - p.samples[len(p.samples)-1].height
+	if remaining < 0 {
+		remaining = 0
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Minute)), true
+}
+
+// stalledCount 同步进度连续没有增加的检查次数
+func (p *syncProgress) stalledCount() int {
+	count := 0
+	for i := len(p.samples) - 1; i > 0; i-- {
+		if p.samples[i].height > p.samples[i-1].height {
+			break
+		}
+		count++
+	}
+	//首次采样时同步进度为0，说明没有在同步
+	if len(p.samples) == 1 && p.samples[0].height == 0 {
+		count = 1
+	}
+	return count
+}
+
+// describe 同步速度及预计剩余时间描述
+func (p *syncProgress) describe(tipHeight int) string {
+	eta, ok := p.eta(tipHeight)
+	if !ok {
+		return "同步速度：未知，预计剩余时间：未知"
+	}
+	return fmt.Sprintf("同步速度：%.1f区块/分钟，预计剩余时间：%s", p.rate(), formatDuration(eta))
+}
+
+// formatDuration 格式化时长，如：2小时15分钟
+func formatDuration(d time.Duration) string {
+	minutes := int(d.Minutes())
+	if minutes < 60 {
+		return fmt.Sprintf("%d分钟", minutes)
+	}
+	if minutes < 24*60 {
+		return fmt.Sprintf("%d小时%d分钟", minutes/60, minutes%60)
+	}
+	return fmt.Sprintf("%d天%d小时", minutes/(24*60), minutes%(24*60)/60)
+}
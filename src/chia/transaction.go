@@ -0,0 +1,280 @@
+package chia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+	"chia_monitor/src/mojo"
+	"chia_monitor/src/monitor"
+	"chia_monitor/src/utils"
+	"chia_monitor/src/wechat"
+)
+
+// 交易类型
+const (
+	TxTypeIncoming       = 0 //收到转账
+	TxTypeOutgoing       = 1 //转出
+	TxTypeCoinbaseReward = 2 //矿池奖励
+	TxTypeFeeReward      = 3 //农民奖励
+	TxTypeIncomingTrade  = 4 //交易收入
+	TxTypeOutgoingTrade  = 5 //交易转出
+)
+
+// txSeenHeightWindow 已通知交易记录保留的区块高度范围
+const txSeenHeightWindow = 10000
+
+// TransactionsRequest 获取交易记录请求
+type TransactionsRequest struct {
+	WalletId int  `json:"wallet_id"`
+	Start    int  `json:"start"`
+	End      int  `json:"end"`
+	Reverse  bool `json:"reverse"`
+}
+
+// TransactionRecord 交易记录
+type TransactionRecord struct {
+	ConfirmedAtHeight int         `json:"confirmed_at_height"`
+	CreatedAtTime     int64       `json:"created_at_time"`
+	ToPuzzleHash      string      `json:"to_puzzle_hash"`
+	ToAddress         string      `json:"to_address"`
+	Amount            mojo.Amount `json:"amount"`
+	FeeAmount         mojo.Amount `json:"fee_amount"`
+	Confirmed         bool        `json:"confirmed"`
+	Sent              int         `json:"sent"`
+	WalletID          int         `json:"wallet_id"`
+	TradeID           string      `json:"trade_id"`
+	Type              int         `json:"type"`
+	Name              string      `json:"name"`
+}
+
+// TransactionsRpcResult 获取交易记录返回
+type TransactionsRpcResult struct {
+	Transactions []TransactionRecord `json:"transactions"`
+	WalletID     int                 `json:"wallet_id"`
+	Error        string              `json:"error"`
+	Success      bool                `json:"success"`
+}
+
+// txState 已通知的交易，重启后不重复通知
+type txState struct {
+	FloorHeight int            `json:"floor_height"` //低于该高度的交易不再通知
+	Seen        map[string]int `json:"seen"`         //已通知的交易名称及确认高度，待确认的交易为0
+}
+
+// IsOutgoing 是否为转出交易
+func (t TransactionRecord) IsOutgoing() bool {
+	return t.Type == TxTypeOutgoing || t.Type == TxTypeOutgoingTrade
+}
+
+// TypeName 交易类型名称
+func (t TransactionRecord) TypeName() string {
+	switch t.Type {
+	case TxTypeIncoming:
+		return "收到转账"
+	case TxTypeOutgoing:
+		return "转出"
+	case TxTypeCoinbaseReward:
+		return "矿池奖励"
+	case TxTypeFeeReward:
+		return "农民奖励"
+	case TxTypeIncomingTrade:
+		return "交易收入"
+	case TxTypeOutgoingTrade:
+		return "交易转出"
+	default:
+		return fmt.Sprintf("未知类型%d", t.Type)
+	}
+}
+
+// GetTransactions 获取最近的交易记录，按确认高度倒序
+func (w Wallet) GetTransactions(count int) (transactionsRpcResult TransactionsRpcResult, err error) {
+	url := w.BaseUrl + "get_transactions"
+	transactionsRequest := TransactionsRequest{WalletId: w.WalletId, Start: 0, End: count, Reverse: true}
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(w.ctx), url, transactionsRequest, "application/json", w.CertPath, w.KeyPath)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &transactionsRpcResult)
+
+	return transactionsRpcResult, err
+}
+
+// WalletTransactionMonitor 钱包交易监控，收到转账时通知，转出时发送严重通知，交易进入内存池时即通知，确认后不再重复通知
+type WalletTransactionMonitor struct {
+	wallet        Wallet
+	mu            sync.Mutex //保护以下状态，防止重新调度时两次Run并发执行
+	state         txState
+	isInitialized bool
+}
+
+// NewWalletTransactionMonitor 创建钱包交易监控，读取已通知的交易，文件不存在时首次获取的交易只记录不通知
+func NewWalletTransactionMonitor(wallet Wallet) *WalletTransactionMonitor {
+	//获取配置文件
+	cfg := config.GetConfig()
+	state := txState{Seen: make(map[string]int)}
+	err := utils.ReadJSONFile(cfg.WalletMonitor.TxStateFile, &state)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Read wallet tx state [%s] failed: %s", cfg.WalletMonitor.TxStateFile, err)
+	}
+	if state.Seen == nil {
+		state.Seen = make(map[string]int)
+	}
+	return &WalletTransactionMonitor{wallet: wallet, state: state, isInitialized: err == nil}
+}
+
+// Name 监控名称
+func (m *WalletTransactionMonitor) Name() string {
+	return "walletTransactions"
+}
+
+// Schedule 默认调度：每隔walletMonitor.interval分钟检查
+func (m *WalletTransactionMonitor) Schedule() monitor.Schedule {
+	return monitor.Every(config.GetConfig().WalletMonitor.Interval)
+}
+
+// Check 获取最近的交易记录
+func (m *WalletTransactionMonitor) Check(ctx context.Context) monitor.Result {
+	_, result := m.check(m.wallet.WithContext(ctx))
+	return result
+}
+
+// check 获取最近的交易记录并生成检查结果，获取失败时result.Err不为空
+func (m *WalletTransactionMonitor) check(wallet Wallet) ([]TransactionRecord, monitor.Result) {
+	//获取配置文件
+	cfg := config.GetConfig()
+	transactionsRpcResult, err := wallet.GetTransactions(cfg.WalletMonitor.TxPageSize)
+	if err != nil {
+		return nil, monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "获取钱包交易错误", err)
+	}
+	if !transactionsRpcResult.Success {
+		return nil, monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "获取钱包交易失败", errors.New(transactionsRpcResult.Error))
+	}
+	transactions := transactionsRpcResult.Transactions
+	return transactions, monitor.NewResult(m.Name(), monitor.StatusOK, fmt.Sprintf("最近交易：%d笔", len(transactions)))
+}
+
+// Run 获取最近的交易记录，通知新出现的交易
+func (m *WalletTransactionMonitor) Run(ctx context.Context) monitor.Result {
+	var event string
+	var detail string
+	var remark string
+
+	//获取配置文件
+	cfg := config.GetConfig()
+	machineName := cfg.Monitor.MachineName
+	event = "钱包交易监控"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transactions, result := m.check(m.wallet.WithContext(ctx))
+	if result.Err != nil {
+		log.Errorf("%s: %s", result.Summary, result.Err)
+		return result
+	}
+
+	newTransactions, changed := m.state.update(transactions)
+	if m.isInitialized {
+		for _, transaction := range newTransactions {
+			confirmed := "待确认"
+			if transaction.Confirmed {
+				confirmed = fmt.Sprintf("确认高度：%d", transaction.ConfirmedAtHeight)
+			}
+			detail = fmt.Sprintf("%s：%s%s，手续费：%s，地址：%s，%s，交易ID：%s",
+				transaction.TypeName(),
+				transaction.Amount,
+				fiatSuffix(ctx, transaction.Amount),
+				transaction.FeeAmount,
+				transaction.ToAddress,
+				confirmed,
+				transaction.Name,
+			)
+			if transaction.IsOutgoing() {
+				log.Warnf("Wallet outgoing transaction: %+v", transaction)
+				remark = "钱包转出，请确认是否为本人操作"
+				wechat.SendCriticalNoticeToWechat(machineName, event, detail, remark)
+			} else {
+				log.Infof("Wallet incoming transaction: %+v", transaction)
+				remark = "钱包收到新的转账"
+				wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+			}
+		}
+	} else {
+		log.Infof("First time to monitor wallet transactions, mark %d transactions as seen", len(newTransactions))
+	}
+	if changed || !m.isInitialized {
+		err := utils.WriteJSONFile(cfg.WalletMonitor.TxStateFile, m.state)
+		if err != nil {
+			log.Errorf("Write wallet tx state [%s] failed: %s", cfg.WalletMonitor.TxStateFile, err)
+		}
+	}
+	m.isInitialized = true
+
+	result.Summary = fmt.Sprintf("%s，新交易：%d笔", result.Summary, len(newTransactions))
+	result.Metrics["new_transactions"] = float64(len(newTransactions))
+	return result
+}
+
+// update 记录新出现的交易，返回未通知过的交易（按确认高度正序）及状态是否变化，
+// 待确认的交易第一次出现在内存池时即返回，确认后只更新确认高度不再返回
+func (s *txState) update(transactions []TransactionRecord) (newTransactions []TransactionRecord, changed bool) {
+	maxHeight := 0
+	pending := make(map[string]bool)
+	for i := len(transactions) - 1; i >= 0; i-- {
+		transaction := transactions[i]
+		height := 0
+		if transaction.Confirmed {
+			if transaction.ConfirmedAtHeight < s.FloorHeight {
+				continue
+			}
+			height = transaction.ConfirmedAtHeight
+			if height > maxHeight {
+				maxHeight = height
+			}
+		} else {
+			pending[transaction.Name] = true
+		}
+		if seenHeight, ok := s.Seen[transaction.Name]; ok {
+			//已通知过的待确认交易已确认
+			if seenHeight != height {
+				s.Seen[transaction.Name] = height
+				changed = true
+			}
+			continue
+		}
+		s.Seen[transaction.Name] = height
+		newTransactions = append(newTransactions, transaction)
+		changed = true
+	}
+
+	//清理已不在内存池中的待确认交易
+	for name, height := range s.Seen {
+		if height == 0 && !pending[name] {
+			delete(s.Seen, name)
+			changed = true
+		}
+	}
+	//清理过早的交易记录
+	if maxHeight-txSeenHeightWindow > s.FloorHeight {
+		s.FloorHeight = maxHeight - txSeenHeightWindow
+		changed = true
+		for name, height := range s.Seen {
+			if height > 0 && height < s.FloorHeight {
+				delete(s.Seen, name)
+			}
+		}
+	}
+
+	return newTransactions, changed
+}
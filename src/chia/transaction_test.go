@@ -0,0 +1,44 @@
+package chia
+
+import "testing"
+
+func TestTxStateUpdate(t *testing.T) {
+	state := txState{Seen: make(map[string]int)}
+	pending := TransactionRecord{Name: "0x01", Type: TxTypeOutgoing}
+	confirmed := TransactionRecord{Name: "0x01", Type: TxTypeOutgoing, Confirmed: true, ConfirmedAtHeight: 100}
+
+	//进入内存池时即通知
+	newTransactions, changed := state.update([]TransactionRecord{pending})
+	if len(newTransactions) != 1 || !changed {
+		t.Fatalf("update pending = %v, %v, want 1 new transaction", newTransactions, changed)
+	}
+	newTransactions, changed = state.update([]TransactionRecord{pending})
+	if len(newTransactions) != 0 || changed {
+		t.Errorf("update same pending = %v, %v, want no change", newTransactions, changed)
+	}
+
+	//确认后只更新确认高度，不再通知
+	newTransactions, changed = state.update([]TransactionRecord{confirmed})
+	if len(newTransactions) != 0 || !changed {
+		t.Errorf("update confirmed = %v, %v, want no new transaction", newTransactions, changed)
+	}
+	if state.Seen["0x01"] != 100 {
+		t.Errorf("seen height = %d, want 100", state.Seen["0x01"])
+	}
+
+	//直接确认的交易通知一次
+	newTransactions, _ = state.update([]TransactionRecord{{Name: "0x02", Confirmed: true, ConfirmedAtHeight: 101}, confirmed})
+	if len(newTransactions) != 1 || newTransactions[0].Name != "0x02" {
+		t.Errorf("update new confirmed = %v, want 0x02", newTransactions)
+	}
+
+	//离开内存池的待确认交易被清理，再次出现时重新通知
+	state.update([]TransactionRecord{{Name: "0x03"}})
+	state.update(nil)
+	if _, ok := state.Seen["0x03"]; ok {
+		t.Error("dropped pending transaction is still seen")
+	}
+	if _, ok := state.Seen["0x01"]; !ok {
+		t.Error("confirmed transaction was removed")
+	}
+}
@@ -1,13 +1,18 @@
 package chia
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 
-	"github.com/robfig/cron"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"chia_monitor/src/config"
+	"chia_monitor/src/mojo"
+	"chia_monitor/src/monitor"
 	"chia_monitor/src/utils"
 	"chia_monitor/src/wechat"
 )
@@ -21,29 +26,67 @@ type Wallet struct {
 	CertPath string
 	KeyPath  string
 	WalletId int
+	ctx      context.Context //rpc请求使用的ctx，为空时不可取消
+}
+
+// WithContext 返回使用ctx发起rpc请求的副本，ctx取消时中断请求
+func (w Wallet) WithContext(ctx context.Context) Wallet {
+	w.ctx = ctx
+	return w
 }
 
 type WalletRpcResult struct {
 	WalletBalance struct {
-		ConfirmedWalletBalance   int `json:"confirmed_wallet_balance"`
-		MaxSendAmount            int `json:"max_send_amount"`
-		PendingChange            int `json:"pending_change"`
-		PendingCoinRemovalCount  int `json:"pending_coin_removal_count"`
-		SpendableBalance         int `json:"spendable_balance"`
-		UnconfirmedWalletBalance int `json:"unconfirmed_wallet_balance"`
-		UnspentCoinCount         int `json:"unspent_coin_count"`
-		WalletID                 int `json:"wallet_id"`
+		ConfirmedWalletBalance   mojo.Amount `json:"confirmed_wallet_balance"`
+		MaxSendAmount            mojo.Amount `json:"max_send_amount"`
+		PendingChange            mojo.Amount `json:"pending_change"`
+		PendingCoinRemovalCount  int         `json:"pending_coin_removal_count"`
+		SpendableBalance         mojo.Amount `json:"spendable_balance"`
+		UnconfirmedWalletBalance mojo.Amount `json:"unconfirmed_wallet_balance"`
+		UnspentCoinCount         int         `json:"unspent_coin_count"`
+		WalletID                 int         `json:"wallet_id"`
 	} `json:"wallet_balance"`
 	Error   string `json:"error"`
 	Success bool   `json:"success"`
 }
 
+// 钱包类型
+const (
+	WalletTypeStandard      = 0  //标准钱包
+	WalletTypeCAT           = 6  //CAT钱包
+	WalletTypeDistributedId = 8  //DID钱包
+	WalletTypePooling       = 9  //矿池钱包
+	WalletTypeNFT           = 10 //NFT钱包
+)
+
+// WalletInfo 钱包信息
+type WalletInfo struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	Type int    `json:"type"`
+	Data string `json:"data"`
+}
+
+// WalletsRpcResult 获取所有钱包返回
+type WalletsRpcResult struct {
+	Wallets []WalletInfo `json:"wallets"`
+	Error   string       `json:"error"`
+	Success bool         `json:"success"`
+}
+
+// WalletSummary 钱包及其余额
+type WalletSummary struct {
+	WalletInfo
+	WalletRpcResult
+	Error string //获取余额失败的原因
+}
+
 // GetWalletBalance 获取钱包余额
 func (w Wallet) GetWalletBalance() (walletRpcResult WalletRpcResult, err error) {
 	url := w.BaseUrl + "get_wallet_balance"
 	walletId := WalletId{WalletId: w.WalletId}
 	//发起请求
-	resp, err := utils.PostHttps(url, walletId, "application/json", w.CertPath, w.KeyPath)
+	resp, err := utils.PostHttpsContext(orBackground(w.ctx), url, walletId, "application/json", w.CertPath, w.KeyPath)
 	if err != nil {
 		log.Error(err)
 		return
@@ -55,8 +98,64 @@ func (w Wallet) GetWalletBalance() (walletRpcResult WalletRpcResult, err error)
 	return walletRpcResult, err
 }
 
-//MonitorWallet 监控钱包状态
-func MonitorWallet(wallet Wallet) {
+// WalletReportMonitor 钱包日报，每日发送所有钱包的余额及内存池推荐手续费，钱包未同步时提示余额可能已过期
+type WalletReportMonitor struct {
+	wallet     Wallet
+	blockChain BlockChain
+	mempool    *MempoolMonitor //提供内存池峰值及趋势
+}
+
+// NewWalletReportMonitor 创建钱包日报
+func NewWalletReportMonitor(wallet Wallet, blockChain BlockChain, mempool *MempoolMonitor) *WalletReportMonitor {
+	return &WalletReportMonitor{wallet: wallet, blockChain: blockChain, mempool: mempool}
+}
+
+// Name 监控名称
+func (m *WalletReportMonitor) Name() string {
+	return "walletReport"
+}
+
+// Schedule 默认调度：按dailyCron每日发送
+func (m *WalletReportMonitor) Schedule() monitor.Schedule {
+	return monitor.Cron(config.GetConfig().Monitor.DailyCron)
+}
+
+// Check 获取所有钱包的余额
+func (m *WalletReportMonitor) Check(ctx context.Context) monitor.Result {
+	return m.check(m.wallet.WithContext(ctx), m.blockChain.WithContext(ctx))
+}
+
+// check 获取所有钱包的余额生成检查结果，钱包未同步时附加提示，获取失败时result.Err不为空
+func (m *WalletReportMonitor) check(wallet Wallet, blockChain BlockChain) monitor.Result {
+	walletBalances, err := wallet.GetAllWalletBalances()
+	if err != nil {
+		return monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "获取钱包余额错误", err)
+	}
+	result := walletBalancesResult(m.Name(), walletBalances)
+	result.Detail = result.Detail + walletStaleRemark(wallet, blockChain)
+	return result
+}
+
+// walletBalancesResult 根据所有钱包的余额生成检查结果，单个钱包获取失败时为Unknown
+func walletBalancesResult(name string, walletBalances []WalletSummary) monitor.Result {
+	result := monitor.NewResult(name, monitor.StatusOK, fmt.Sprintf("钱包：%d个", len(walletBalances)))
+	var details []string
+	for _, walletBalance := range walletBalances {
+		details = append(details, walletBalance.String())
+		if walletBalance.Error != "" {
+			result.Status = monitor.StatusUnknown
+			continue
+		}
+		if walletBalance.Type != WalletTypeCAT {
+			result.Metrics[fmt.Sprintf("wallet_%d_balance", walletBalance.Id)] = walletBalance.WalletBalance.ConfirmedWalletBalance.XCH()
+		}
+	}
+	result.Detail = strings.Join(details, "\n")
+	return result
+}
+
+// Run 发送所有钱包的余额，附加内存池状态及推荐手续费
+func (m *WalletReportMonitor) Run(ctx context.Context) monitor.Result {
 	var event string
 	var detail string
 	var remark string
@@ -65,39 +164,204 @@ func MonitorWallet(wallet Wallet) {
 	cfg := config.GetConfig()
 	machineName := cfg.Monitor.MachineName
 	event = "钱包状态监控"
+	blockChain := m.blockChain.WithContext(ctx)
+
+	//获取所有钱包余额
+	result := m.check(m.wallet.WithContext(ctx), blockChain)
+	if result.Err != nil {
+		log.Errorf("%s: %s", result.Summary, result.Err)
+		//发送获取rpc失败微信通知
+		detail = result.Err.Error()
+		remark = result.Summary
+	} else {
+		log.Info("Get all wallet balances success!")
+		//发送获取钱包余额微信通知
+		detail = result.Detail
+		remark = "获取钱包余额成功"
+	}
+	detail = detail + "\n" + m.mempool.Report(blockChain)
+	wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+	return result
+}
 
-	//创建定时任务
-	c := cron.New()
-	err := c.AddFunc(cfg.Monitor.DailyCron, func() {
-		//获取钱包余额
-		walletRpcResult, err := wallet.GetWalletBalance()
+// GetWallets 获取所有钱包：标准钱包、CAT钱包、矿池钱包等
+func (w Wallet) GetWallets() (walletsRpcResult WalletsRpcResult, err error) {
+	url := w.BaseUrl + "get_wallets"
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(w.ctx), url, struct{}{}, "application/json", w.CertPath, w.KeyPath)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &walletsRpcResult)
+
+	return walletsRpcResult, err
+}
+
+// WithWalletId 返回指定钱包id的钱包对象
+func (w Wallet) WithWalletId(walletId int) Wallet {
+	w.WalletId = walletId
+	return w
+}
+
+// GetAllWalletBalances 获取所有钱包的余额，单个钱包获取失败时记录在Error中
+func (w Wallet) GetAllWalletBalances() (walletBalances []WalletSummary, err error) {
+	walletsRpcResult, err := w.GetWallets()
+	if err != nil {
+		return
+	}
+	if !walletsRpcResult.Success {
+		return nil, errors.Errorf("get wallets failed: %s", walletsRpcResult.Error)
+	}
+
+	for _, walletInfo := range walletsRpcResult.Wallets {
+		walletBalance := WalletSummary{WalletInfo: walletInfo}
+		walletRpcResult, err := w.WithWalletId(walletInfo.Id).GetWalletBalance()
 		if err != nil {
-			log.Error("Get wallet balance err: ", err)
-			//发送获取rpc失败微信通知
-			detail = err.Error()
-			remark = "获取钱包余额错误"
+			walletBalance.Error = err.Error()
+		} else if !walletRpcResult.Success {
+			walletBalance.Error = walletRpcResult.Error
 		} else {
-			//获取成功
-			if walletRpcResult.Success {
-				log.Info("Get waller state rpc result success!")
-				//发送获取钱包余额微信通知
-				detail = fmt.Sprintf("钱包余额: %.12f", float64(walletRpcResult.WalletBalance.ConfirmedWalletBalance)/float64(1000000000000))
-				remark = "获取钱包余额成功"
-			} else {
-				log.Info("Get waller state rpc result failed: ", walletRpcResult.Error)
-				//发送获取钱包余额微信通知
-				detail = walletRpcResult.Error
-				remark = "获取钱包余额失败"
-			}
+			walletBalance.WalletRpcResult = walletRpcResult
 		}
-		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
-	})
+		walletBalances = append(walletBalances, walletBalance)
+	}
+
+	return walletBalances, nil
+}
+
+// TypeName 钱包类型名称
+func (w WalletInfo) TypeName() string {
+	switch w.Type {
+	case WalletTypeStandard:
+		return "标准钱包"
+	case WalletTypeCAT:
+		return "CAT钱包"
+	case WalletTypeDistributedId:
+		return "DID钱包"
+	case WalletTypePooling:
+		return "矿池钱包"
+	case WalletTypeNFT:
+		return "NFT钱包"
+	default:
+		return fmt.Sprintf("类型%d钱包", w.Type)
+	}
+}
+
+// FormatAmount 按钱包类型格式化数量，CAT钱包单位为CAT，其他钱包单位为XCH并附带法币价值
+func (w WalletInfo) FormatAmount(amount mojo.Amount) string {
+	if w.Type == WalletTypeCAT {
+		return amount.FormatCAT(3) + " CAT"
+	}
+	return amount.String() + " XCH" + fiatSuffix(context.Background(), amount)
+}
+
+// ParseAmount 按钱包类型解析配置中的数量
+func (w WalletInfo) ParseAmount(s string) (mojo.Amount, error) {
+	if w.Type == WalletTypeCAT {
+		return mojo.ParseCAT(s)
+	}
+	return mojo.ParseXCH(s)
+}
+
+// String 钱包余额描述
+func (w WalletSummary) String() string {
+	name := fmt.Sprintf("钱包%d %s（%s）", w.Id, w.Name, w.TypeName())
+	if w.Error != "" {
+		return fmt.Sprintf("%s：获取余额失败：%s", name, w.Error)
+	}
+	return fmt.Sprintf("%s：余额 %s", name, w.FormatAmount(w.WalletBalance.ConfirmedWalletBalance))
+}
+
+// WalletBalanceMonitor 按照配置的规则监控各个钱包的余额：最低余额、余额变化、余额异常减少、未确认余额、目标余额
+type WalletBalanceMonitor struct {
+	wallet     Wallet
+	blockChain BlockChain
+	mu         sync.Mutex //保护states，防止重新调度时两次Run并发执行
+	states     map[int]*walletCheckState
+}
+
+// NewWalletBalanceMonitor 创建钱包余额监控
+func NewWalletBalanceMonitor(wallet Wallet, blockChain BlockChain) *WalletBalanceMonitor {
+	return &WalletBalanceMonitor{wallet: wallet, blockChain: blockChain, states: make(map[int]*walletCheckState)}
+}
+
+// Name 监控名称
+func (m *WalletBalanceMonitor) Name() string {
+	return "walletBalance"
+}
 
+// Schedule 默认调度：每隔walletMonitor.interval分钟检查
+func (m *WalletBalanceMonitor) Schedule() monitor.Schedule {
+	return monitor.Every(config.GetConfig().WalletMonitor.Interval)
+}
+
+// Check 检查配置了规则的钱包是否低于最低余额
+func (m *WalletBalanceMonitor) Check(ctx context.Context) monitor.Result {
+	_, result := m.check(m.wallet.WithContext(ctx))
+	return result
+}
+
+// check 获取所有钱包的余额并生成检查结果，获取失败时result.Err不为空
+func (m *WalletBalanceMonitor) check(wallet Wallet) ([]WalletSummary, monitor.Result) {
+	walletBalances, err := wallet.GetAllWalletBalances()
 	if err != nil {
-		log.Fatal("Start wallet cron task err: ", err)
-		return
+		return nil, monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "获取钱包余额错误", err)
 	}
+	return walletBalances, m.result(walletBalances)
+}
 
-	c.Start()
-	log.Info("Start wallet cron task success!")
+// result 根据规则生成检查结果，低于最低余额时为Warning
+func (m *WalletBalanceMonitor) result(walletBalances []WalletSummary) monitor.Result {
+	//获取配置文件
+	cfg := config.GetConfig()
+	result := walletBalancesResult(m.Name(), walletBalances)
+	var belowMin []string
+	for _, walletBalance := range walletBalances {
+		rule := cfg.WalletMonitor.GetWalletRule(walletBalance.Id, walletBalance.Name)
+		if rule == nil || walletBalance.Error != "" {
+			continue
+		}
+		if minBalance, ok := parseRuleAmount(walletBalance, "minBalance", rule.MinBalance); ok && walletBalance.WalletBalance.ConfirmedWalletBalance < minBalance {
+			belowMin = append(belowMin, fmt.Sprintf("钱包%d %s", walletBalance.Id, walletBalance.Name))
+		}
+	}
+	if len(belowMin) > 0 {
+		result.Status = monitor.Worse(result.Status, monitor.StatusWarning)
+		result.Summary = fmt.Sprintf("%s余额不足", strings.Join(belowMin, "、"))
+	}
+	return result
+}
+
+// Run 按照规则检查各个钱包的余额
+func (m *WalletBalanceMonitor) Run(ctx context.Context) monitor.Result {
+	//获取配置文件
+	cfg := config.GetConfig()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	wallet := m.wallet.WithContext(ctx)
+	blockChain := m.blockChain.WithContext(ctx)
+
+	walletBalances, result := m.check(wallet)
+	if result.Err != nil {
+		log.Errorf("%s: %s", result.Summary, result.Err)
+		return result
+	}
+
+	for _, walletBalance := range walletBalances {
+		rule := cfg.WalletMonitor.GetWalletRule(walletBalance.Id, walletBalance.Name)
+		if rule == nil || walletBalance.Error != "" {
+			continue
+		}
+		state, ok := m.states[walletBalance.Id]
+		if !ok {
+			state = &walletCheckState{}
+			m.states[walletBalance.Id] = state
+		}
+		state.check(wallet, blockChain, walletBalance, rule)
+	}
+	return result
 }
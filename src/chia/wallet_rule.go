@@ -0,0 +1,165 @@
+package chia
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+	"chia_monitor/src/mojo"
+	"chia_monitor/src/wechat"
+)
+
+const walletBalanceEvent = "钱包余额监控"
+
+// walletCheckState 钱包余额检查状态
+type walletCheckState struct {
+	hasLast       bool
+	lastBalance   mojo.Amount
+	lastAvailable mojo.Amount //上次的可用余额加待确认找零
+	lastCheckTime time.Time
+	belowMin      bool
+	pendingSince  time.Time //未确认余额与已确认余额开始不一致的时间
+	pendingAlert  bool
+	aboveTarget   bool
+}
+
+// check 按照规则检查钱包余额，发送通知
+func (s *walletCheckState) check(wallet Wallet, blockChain BlockChain, walletBalance WalletSummary, rule *config.WalletRule) {
+	balance := walletBalance.WalletBalance.ConfirmedWalletBalance
+	spendable := walletBalance.WalletBalance.SpendableBalance
+	//本钱包转出时，被花费的整个coin从可用余额扣除，找零在确认前计入pending_change，加回找零后才是实际减少的数量
+	available := spendable + walletBalance.WalletBalance.PendingChange
+	unconfirmed := walletBalance.WalletBalance.UnconfirmedWalletBalance
+	name := fmt.Sprintf("钱包%d %s", walletBalance.Id, walletBalance.Name)
+	now := time.Now()
+
+	//余额变化
+	if rule.ChangeAlert && s.hasLast && balance != s.lastBalance {
+		diff, negative := balance.Diff(s.lastBalance)
+		detail := fmt.Sprintf("%s余额变化：%s%s，当前余额：%s", name, sign(negative), walletBalance.FormatAmount(diff), walletBalance.FormatAmount(balance))
+		s.notify(detail+walletStaleRemark(wallet, blockChain), "钱包余额发生变化", false)
+	}
+
+	//可用余额异常减少，减少的数量超过本钱包发起的转出交易
+	keepLast := false
+	if maxDrop, ok := parseRuleAmount(walletBalance, "maxDrop", rule.MaxDrop); ok && s.hasLast && available < s.lastAvailable {
+		drop := s.lastAvailable - available
+		//获取转出交易失败时无法区分正常转出，跳过本次检查，保留上次的可用余额及检查时间，下次一起比较
+		outgoing, ok := outgoingSince(wallet.WithWalletId(walletBalance.Id), s.lastCheckTime, walletBalance.Type == WalletTypeStandard)
+		keepLast = !ok
+		if ok && drop > outgoing && drop-outgoing > maxDrop {
+			detail := fmt.Sprintf("%s可用余额减少：%s，本钱包转出：%s，当前可用余额：%s",
+				name, walletBalance.FormatAmount(drop), walletBalance.FormatAmount(outgoing), walletBalance.FormatAmount(spendable))
+			s.notify(detail, "可用余额异常减少，请确认钱包是否安全", true)
+		}
+	}
+
+	//未确认余额与已确认余额长时间不一致
+	if unconfirmed != balance {
+		if s.pendingSince.IsZero() {
+			s.pendingSince = now
+		}
+		if rule.PendingMaxMinutes > 0 && !s.pendingAlert && now.Sub(s.pendingSince) >= time.Duration(rule.PendingMaxMinutes)*time.Minute {
+			s.pendingAlert = true
+			detail := fmt.Sprintf("%s已确认余额：%s，未确认余额：%s，已持续%d分钟",
+				name, walletBalance.FormatAmount(balance), walletBalance.FormatAmount(unconfirmed), int(now.Sub(s.pendingSince).Minutes()))
+			s.notify(detail+walletStaleRemark(wallet, blockChain), "交易长时间未确认", false)
+		}
+	} else {
+		if s.pendingAlert {
+			detail := fmt.Sprintf("%s未确认交易已全部确认，当前余额：%s", name, walletBalance.FormatAmount(balance))
+			s.notify(detail, "交易已确认", false)
+		}
+		s.pendingSince = time.Time{}
+		s.pendingAlert = false
+	}
+
+	//余额越过目标余额
+	if target, ok := parseRuleAmount(walletBalance, "targetBalance", rule.TargetBalance); ok {
+		above := balance >= target
+		if s.hasLast && above != s.aboveTarget {
+			detail := fmt.Sprintf("%s余额：%s，目标余额：%s", name, walletBalance.FormatAmount(balance), walletBalance.FormatAmount(target))
+			if above {
+				s.notify(detail, "钱包余额已达到目标余额", false)
+			} else {
+				s.notify(detail, "钱包余额已低于目标余额", false)
+			}
+		}
+		s.aboveTarget = above
+	}
+
+	//最低余额
+	if minBalance, ok := parseRuleAmount(walletBalance, "minBalance", rule.MinBalance); ok {
+		if balance < minBalance && !s.belowMin {
+			s.belowMin = true
+			detail := fmt.Sprintf("%s余额：%s，低于最低余额：%s", name, walletBalance.FormatAmount(balance), walletBalance.FormatAmount(minBalance))
+			s.notify(detail+walletStaleRemark(wallet, blockChain), "钱包余额不足", false)
+		} else if balance >= minBalance && s.belowMin {
+			s.belowMin = false
+			detail := fmt.Sprintf("%s余额：%s，已恢复到最低余额以上", name, walletBalance.FormatAmount(balance))
+			s.notify(detail, "钱包余额已恢复", false)
+		}
+	}
+
+	s.hasLast = true
+	s.lastBalance = balance
+	if !keepLast {
+		s.lastAvailable = available
+		s.lastCheckTime = now
+	}
+}
+
+// notify 发送钱包余额通知
+func (s *walletCheckState) notify(detail, remark string, isCritical bool) {
+	machineName := config.GetConfig().Monitor.MachineName
+	if isCritical {
+		wechat.SendCriticalNoticeToWechat(machineName, walletBalanceEvent, detail, remark)
+	} else {
+		wechat.SendChiaMonitorNoticeToWechat(machineName, walletBalanceEvent, detail, remark)
+	}
+}
+
+// parseRuleAmount 解析规则中配置的数量，未配置或配置错误时返回false
+func parseRuleAmount(walletBalance WalletSummary, field, value string) (mojo.Amount, bool) {
+	if value == "" {
+		return 0, false
+	}
+	amount, err := walletBalance.ParseAmount(value)
+	if err != nil {
+		log.Errorf("Invalid %s %s of wallet %d: %s", field, value, walletBalance.Id, err)
+		return 0, false
+	}
+	return amount, true
+}
+
+// outgoingSince 钱包在since之后发起的转出交易数量，手续费以XCH支付，只有标准钱包includeFee为true时计入，获取交易失败时返回false
+func outgoingSince(wallet Wallet, since time.Time, includeFee bool) (outgoing mojo.Amount, ok bool) {
+	transactionsRpcResult, err := wallet.GetTransactions(config.GetConfig().WalletMonitor.TxPageSize)
+	if err != nil {
+		log.Error("Get wallet transactions err: ", err)
+		return 0, false
+	}
+	if !transactionsRpcResult.Success {
+		log.Error("Get wallet transactions rpc result failed: ", transactionsRpcResult.Error)
+		return 0, false
+	}
+	for _, transaction := range transactionsRpcResult.Transactions {
+		if transaction.IsOutgoing() && transaction.CreatedAtTime >= since.Unix() {
+			outgoing += transaction.Amount
+			if includeFee {
+				outgoing += transaction.FeeAmount
+			}
+		}
+	}
+	return outgoing, true
+}
+
+// sign 数量变化的符号
+func sign(negative bool) string {
+	if negative {
+		return "-"
+	}
+	return "+"
+}
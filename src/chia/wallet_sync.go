@@ -0,0 +1,230 @@
+package chia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+	"chia_monitor/src/monitor"
+	"chia_monitor/src/utils"
+	"chia_monitor/src/wechat"
+)
+
+// SyncStatusRpcResult 钱包同步状态
+type SyncStatusRpcResult struct {
+	GenesisInitialized bool   `json:"genesis_initialized"`
+	Synced             bool   `json:"synced"`
+	Syncing            bool   `json:"syncing"`
+	Error              string `json:"error"`
+	Success            bool   `json:"success"`
+}
+
+// HeightInfoRpcResult 钱包区块高度
+type HeightInfoRpcResult struct {
+	Height  int    `json:"height"`
+	Error   string `json:"error"`
+	Success bool   `json:"success"`
+}
+
+// WalletSyncStatus 钱包同步健康状态
+type WalletSyncStatus struct {
+	Synced     bool //钱包是否已同步
+	Syncing    bool //钱包是否正在同步
+	Height     int  //钱包区块高度
+	PeakHeight int  //全节点最新区块高度
+	Lag        int  //钱包落后全节点的区块数
+}
+
+// GetSyncStatus 获取钱包同步状态
+func (w Wallet) GetSyncStatus() (syncStatusRpcResult SyncStatusRpcResult, err error) {
+	url := w.BaseUrl + "get_sync_status"
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(w.ctx), url, struct{}{}, "application/json", w.CertPath, w.KeyPath)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &syncStatusRpcResult)
+
+	return syncStatusRpcResult, err
+}
+
+// GetHeightInfo 获取钱包区块高度
+func (w Wallet) GetHeightInfo() (heightInfoRpcResult HeightInfoRpcResult, err error) {
+	url := w.BaseUrl + "get_height_info"
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(w.ctx), url, struct{}{}, "application/json", w.CertPath, w.KeyPath)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &heightInfoRpcResult)
+
+	return heightInfoRpcResult, err
+}
+
+// GetWalletSyncStatus 获取钱包同步状态，并与全节点最新区块高度比较
+func (w Wallet) GetWalletSyncStatus(blockChain BlockChain) (status WalletSyncStatus, err error) {
+	syncStatusRpcResult, err := w.GetSyncStatus()
+	if err != nil {
+		return
+	}
+	if !syncStatusRpcResult.Success {
+		return status, errors.Errorf("get wallet sync status failed: %s", syncStatusRpcResult.Error)
+	}
+	status.Synced = syncStatusRpcResult.Synced
+	status.Syncing = syncStatusRpcResult.Syncing
+
+	heightInfoRpcResult, err := w.GetHeightInfo()
+	if err != nil {
+		return
+	}
+	if !heightInfoRpcResult.Success {
+		return status, errors.Errorf("get wallet height info failed: %s", heightInfoRpcResult.Error)
+	}
+	status.Height = heightInfoRpcResult.Height
+
+	blockchainStateRpcResult, err := blockChain.GetBlockchainState()
+	if err != nil {
+		return
+	}
+	if !blockchainStateRpcResult.Success {
+		return status, errors.Errorf("get blockchain state failed: %s", blockchainStateRpcResult.Error)
+	}
+	status.PeakHeight = blockchainStateRpcResult.BlockchainState.Peak.Height
+	status.Lag = status.PeakHeight - status.Height
+
+	return status, nil
+}
+
+// IsHealthy 钱包已同步且落后全节点的区块数不超过配置的最大值
+func (s WalletSyncStatus) IsHealthy() bool {
+	return s.Synced && s.Lag <= config.GetConfig().WalletMonitor.MaxHeightLag
+}
+
+// String 钱包同步状态描述
+func (s WalletSyncStatus) String() string {
+	state := "已同步"
+	if s.Syncing {
+		state = "同步中"
+	} else if !s.Synced {
+		state = "未同步"
+	}
+	return fmt.Sprintf("钱包%s，钱包高度：%d，全节点高度：%d，落后%d个区块", state, s.Height, s.PeakHeight, s.Lag)
+}
+
+// walletStaleRemark 钱包未同步或落后过多时，余额报告附带的过期提示
+func walletStaleRemark(wallet Wallet, blockChain BlockChain) string {
+	status, err := wallet.GetWalletSyncStatus(blockChain)
+	if err != nil {
+		log.Error("Get wallet sync status err: ", err)
+		return fmt.Sprintf("\n（获取钱包同步状态失败，余额可能已过期：%s）", err)
+	}
+	if !status.IsHealthy() {
+		return fmt.Sprintf("\n（%s，余额可能已过期）", status)
+	}
+	return ""
+}
+
+// WalletSyncMonitor 钱包同步监控，钱包未同步或落后全节点过多时通知
+type WalletSyncMonitor struct {
+	wallet      Wallet
+	blockChain  BlockChain
+	mu          sync.Mutex //保护isUnhealthy，防止重新调度时两次Run并发执行
+	isUnhealthy bool
+}
+
+// NewWalletSyncMonitor 创建钱包同步监控
+func NewWalletSyncMonitor(wallet Wallet, blockChain BlockChain) *WalletSyncMonitor {
+	return &WalletSyncMonitor{wallet: wallet, blockChain: blockChain}
+}
+
+// Name 监控名称
+func (m *WalletSyncMonitor) Name() string {
+	return "walletSync"
+}
+
+// Schedule 默认调度：每隔walletMonitor.interval分钟检查
+func (m *WalletSyncMonitor) Schedule() monitor.Schedule {
+	return monitor.Every(config.GetConfig().WalletMonitor.Interval)
+}
+
+// Check 检查钱包同步状态
+func (m *WalletSyncMonitor) Check(ctx context.Context) monitor.Result {
+	_, result := m.check(ctx)
+	return result
+}
+
+// check 获取钱包同步状态并生成检查结果，获取失败时result.Err不为空
+func (m *WalletSyncMonitor) check(ctx context.Context) (WalletSyncStatus, monitor.Result) {
+	status, err := m.wallet.WithContext(ctx).GetWalletSyncStatus(m.blockChain.WithContext(ctx))
+	if err != nil {
+		return status, monitor.ErrorResult(m.Name(), monitor.StatusCritical, "获取钱包同步状态错误", err)
+	}
+	return status, m.result(status)
+}
+
+// result 根据钱包同步状态生成检查结果
+func (m *WalletSyncMonitor) result(status WalletSyncStatus) monitor.Result {
+	result := monitor.NewResult(m.Name(), monitor.StatusOK, status.String())
+	if !status.IsHealthy() {
+		result.Status = monitor.StatusWarning
+	}
+	result.Metrics["wallet_height"] = float64(status.Height)
+	result.Metrics["wallet_height_lag"] = float64(status.Lag)
+	return result
+}
+
+// Run 检查钱包同步状态，异常及恢复时通知
+func (m *WalletSyncMonitor) Run(ctx context.Context) monitor.Result {
+	var event string
+	var detail string
+	var remark string
+
+	//获取配置文件
+	cfg := config.GetConfig()
+	machineName := cfg.Monitor.MachineName
+	event = "钱包同步监控"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status, result := m.check(ctx)
+	if result.Err != nil {
+		log.Errorf("%s: %s", result.Summary, result.Err)
+		if !m.isUnhealthy {
+			m.isUnhealthy = true
+			detail = result.Err.Error()
+			remark = result.Summary
+			wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+		}
+		return result
+	}
+	if !status.IsHealthy() {
+		log.Errorf("Wallet is not healthy: %+v", status)
+		if !m.isUnhealthy {
+			m.isUnhealthy = true
+			detail = status.String()
+			remark = fmt.Sprintf("钱包未同步或落后超过%d个区块，余额可能已过期", cfg.WalletMonitor.MaxHeightLag)
+			wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+		}
+	} else {
+		log.Infof("Wallet is synced: %+v", status)
+		if m.isUnhealthy {
+			m.isUnhealthy = false
+			detail = status.String()
+			remark = "钱包同步已恢复"
+			wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, remark)
+		}
+	}
+	return result
+}
@@ -0,0 +1,346 @@
+package chia
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/bech32"
+	"chia_monitor/src/config"
+	"chia_monitor/src/mojo"
+	"chia_monitor/src/monitor"
+	"chia_monitor/src/utils"
+	"chia_monitor/src/wechat"
+)
+
+// watchNoticeMaxCoins 每条通知中最多列出的coin数量
+const watchNoticeMaxCoins = 10
+
+// CoinRecordsRequest 按puzzle hash获取coin记录请求
+type CoinRecordsRequest struct {
+	PuzzleHash        string `json:"puzzle_hash"`
+	IncludeSpentCoins bool   `json:"include_spent_coins"`
+	StartHeight       int    `json:"start_height,omitempty"`
+}
+
+// Coin coin信息
+type Coin struct {
+	Amount         mojo.Amount `json:"amount"`
+	ParentCoinInfo string      `json:"parent_coin_info"`
+	PuzzleHash     string      `json:"puzzle_hash"`
+}
+
+// CoinRecord coin记录
+type CoinRecord struct {
+	Coin                Coin  `json:"coin"`
+	Coinbase            bool  `json:"coinbase"`
+	ConfirmedBlockIndex int   `json:"confirmed_block_index"`
+	Spent               bool  `json:"spent"`
+	SpentBlockIndex     int   `json:"spent_block_index"`
+	Timestamp           int64 `json:"timestamp"`
+}
+
+// CoinRecordsRpcResult 获取coin记录返回
+type CoinRecordsRpcResult struct {
+	CoinRecords []CoinRecord `json:"coin_records"`
+	Error       string       `json:"error"`
+	Success     bool         `json:"success"`
+}
+
+// watchCoin 已知的未花费coin
+type watchCoin struct {
+	Amount              mojo.Amount `json:"amount"`
+	ConfirmedBlockIndex int         `json:"confirmed_block_index"`
+}
+
+// watchAddressState 观察地址的状态
+type watchAddressState struct {
+	Height  int                  `json:"height"`  //上次检查时全节点的区块高度
+	Balance mojo.Amount          `json:"balance"` //上次检查时的余额
+	Unspent map[string]watchCoin `json:"unspent"` //上次检查时未花费的coin
+}
+
+// GetCoinRecordsByPuzzleHash 按puzzle hash获取coin记录
+func (b BlockChain) GetCoinRecordsByPuzzleHash(puzzleHash string, includeSpentCoins bool, startHeight int) (coinRecordsRpcResult CoinRecordsRpcResult, err error) {
+	url := b.BaseUrl + "get_coin_records_by_puzzle_hash"
+	coinRecordsRequest := CoinRecordsRequest{
+		PuzzleHash:        puzzleHash,
+		IncludeSpentCoins: includeSpentCoins,
+		StartHeight:       startHeight,
+	}
+	//发起请求
+	resp, err := utils.PostHttpsContext(orBackground(b.ctx), url, coinRecordsRequest, "application/json", b.CertPath, b.KeyPath)
+	if err != nil {
+		return
+	}
+	log.Debug(string(resp))
+
+	err = json.Unmarshal(resp, &coinRecordsRpcResult)
+
+	return coinRecordsRpcResult, err
+}
+
+// Id 计算coin id：sha256(parent_coin_info + puzzle_hash + amount)
+func (c Coin) Id() (string, error) {
+	parentCoinInfo, err := hex.DecodeString(strings.TrimPrefix(c.ParentCoinInfo, "0x"))
+	if err != nil {
+		return "", errors.Wrap(err, "decode parent coin info")
+	}
+	puzzleHash, err := hex.DecodeString(strings.TrimPrefix(c.PuzzleHash, "0x"))
+	if err != nil {
+		return "", errors.Wrap(err, "decode puzzle hash")
+	}
+
+	//amount按clvm整数编码：最短的有符号大端字节
+	amount := new(big.Int).SetUint64(c.Amount.Mojo())
+	var amountBytes []byte
+	if amount.Sign() > 0 {
+		amountBytes = make([]byte, (amount.BitLen()+8)/8)
+		amount.FillBytes(amountBytes)
+	}
+
+	hash := sha256.New()
+	hash.Write(parentCoinInfo)
+	hash.Write(puzzleHash)
+	hash.Write(amountBytes)
+	return "0x" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// AddressToPuzzleHash 将xch1...地址解码为0x开头的puzzle hash，地址前缀必须为prefix，防止在主网观察测试网地址
+func AddressToPuzzleHash(address, prefix string) (string, error) {
+	puzzleHash, err := bech32.DecodePuzzleHash(address, prefix)
+	if err != nil {
+		return "", err
+	}
+	return "0x" + hex.EncodeToString(puzzleHash), nil
+}
+
+// WatchAddressMonitor 观察地址监控，通过全节点的coin记录监控收款和花费
+type WatchAddressMonitor struct {
+	blockChain BlockChain
+	mu         sync.Mutex //保护states，防止重新调度时两次Run并发执行
+	states     map[string]*watchAddressState
+}
+
+// NewWatchAddressMonitor 创建观察地址监控，读取已知的coin记录，文件不存在时首次检查只记录不通知
+func NewWatchAddressMonitor(blockChain BlockChain) *WatchAddressMonitor {
+	//获取配置文件
+	cfg := config.GetConfig()
+	states := make(map[string]*watchAddressState)
+	err := utils.ReadJSONFile(cfg.AddressWatch.StateFile, &states)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Read address watch state [%s] failed: %s", cfg.AddressWatch.StateFile, err)
+	}
+	return &WatchAddressMonitor{blockChain: blockChain, states: states}
+}
+
+// Name 监控名称
+func (m *WatchAddressMonitor) Name() string {
+	return "watchAddresses"
+}
+
+// Schedule 默认调度：每隔addressWatch.interval分钟检查
+func (m *WatchAddressMonitor) Schedule() monitor.Schedule {
+	return monitor.Every(config.GetConfig().AddressWatch.Interval)
+}
+
+// Check 获取各观察地址的余额
+func (m *WatchAddressMonitor) Check(ctx context.Context) monitor.Result {
+	_, result := m.check(m.blockChain.WithContext(ctx))
+	return result
+}
+
+// check 获取各观察地址当前的状态并生成检查结果，获取失败的地址不在返回的状态中
+func (m *WatchAddressMonitor) check(blockChain BlockChain) (map[string]*watchAddressState, monitor.Result) {
+	//获取配置文件
+	cfg := config.GetConfig()
+	result := monitor.NewResult(m.Name(), monitor.StatusOK, fmt.Sprintf("观察地址：%d个", len(cfg.AddressWatch.Addresses)))
+	states := make(map[string]*watchAddressState)
+	var details []string
+	for _, watchAddress := range cfg.AddressWatch.Addresses {
+		state, err := getWatchAddressState(blockChain, watchAddress.Address)
+		if err != nil {
+			log.Errorf("Check watch address %s err: %s", watchAddress.Address, err)
+			result.Status = monitor.StatusUnknown
+			result.Err = err
+			details = append(details, fmt.Sprintf("%s：检查失败：%s", watchAddress.Name, err))
+			continue
+		}
+		states[watchAddress.Address] = state
+		details = append(details, fmt.Sprintf("%s：%s XCH", watchAddress.Name, state.Balance))
+		result.Metrics["balance_"+watchAddress.Name] = state.Balance.XCH()
+	}
+	result.Detail = strings.Join(details, "\n")
+	return states, result
+}
+
+// Run 检查各观察地址，收到coin和花费coin时通知
+func (m *WatchAddressMonitor) Run(ctx context.Context) monitor.Result {
+	//获取配置文件
+	cfg := config.GetConfig()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blockChain := m.blockChain.WithContext(ctx)
+
+	newStates, result := m.check(blockChain)
+	var isChanged bool
+	var details []string
+	for _, watchAddress := range cfg.AddressWatch.Addresses {
+		newState, ok := newStates[watchAddress.Address]
+		if !ok {
+			continue
+		}
+		state, ok := m.states[watchAddress.Address]
+		if !ok {
+			log.Infof("First time to watch address %s, balance: %s", watchAddress.Address, newState.Balance)
+		}
+		changed, err := notifyWatchAddress(blockChain, watchAddress, state, newState)
+		if err != nil {
+			log.Errorf("Check watch address %s err: %s", watchAddress.Address, err)
+			result.Status = monitor.StatusUnknown
+			result.Err = err
+			details = append(details, fmt.Sprintf("%s：检查失败：%s", watchAddress.Name, err))
+			continue
+		}
+		m.states[watchAddress.Address] = newState
+		//只有高度变化时不重写状态文件，重启后从上次保存的高度开始检查已花费的coin
+		if changed {
+			isChanged = true
+		}
+	}
+	if isChanged {
+		err := utils.WriteJSONFile(cfg.AddressWatch.StateFile, m.states)
+		if err != nil {
+			log.Errorf("Write address watch state [%s] failed: %s", cfg.AddressWatch.StateFile, err)
+		}
+	}
+	if len(details) > 0 {
+		result.Detail = result.Detail + "\n" + strings.Join(details, "\n")
+	}
+	return result
+}
+
+// getWatchAddressState 获取观察地址当前的区块高度、余额及未花费的coin
+func getWatchAddressState(blockChain BlockChain, address string) (*watchAddressState, error) {
+	puzzleHash, err := AddressToPuzzleHash(address, config.GetConfig().AddressWatch.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	blockchainStateRpcResult, err := blockChain.GetBlockchainState()
+	if err != nil {
+		return nil, err
+	}
+	if !blockchainStateRpcResult.Success {
+		return nil, errors.Errorf("get blockchain state failed: %s", blockchainStateRpcResult.Error)
+	}
+
+	//当前未花费的coin
+	unspentRecords, err := blockChain.GetCoinRecordsByPuzzleHash(puzzleHash, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !unspentRecords.Success {
+		return nil, errors.Errorf("get coin records failed: %s", unspentRecords.Error)
+	}
+	state := &watchAddressState{
+		Height:  blockchainStateRpcResult.BlockchainState.Peak.Height,
+		Unspent: make(map[string]watchCoin),
+	}
+	for _, coinRecord := range unspentRecords.CoinRecords {
+		coinId, err := coinRecord.Coin.Id()
+		if err != nil {
+			return nil, err
+		}
+		state.Unspent[coinId] = watchCoin{Amount: coinRecord.Coin.Amount, ConfirmedBlockIndex: coinRecord.ConfirmedBlockIndex}
+		state.Balance += coinRecord.Coin.Amount
+	}
+	return state, nil
+}
+
+// notifyWatchAddress 将观察地址的新状态与上次的状态比较，发送收款和花费通知，返回coin是否有变化，没有上次的状态时只记录不通知
+func notifyWatchAddress(blockChain BlockChain, watchAddress config.WatchAddress, state, newState *watchAddressState) (bool, error) {
+	if state == nil {
+		return true, nil
+	}
+
+	var incoming, spent []string
+	var incomingAmount, spentAmount mojo.Amount
+	for coinId, coin := range newState.Unspent {
+		if _, ok := state.Unspent[coinId]; !ok {
+			incoming = append(incoming, fmt.Sprintf("%s XCH，确认高度：%d，coin：%s", coin.Amount, coin.ConfirmedBlockIndex, coinId))
+			incomingAmount += coin.Amount
+		}
+	}
+	for coinId, coin := range state.Unspent {
+		if _, ok := newState.Unspent[coinId]; !ok {
+			spent = append(spent, fmt.Sprintf("%s XCH，coin：%s", coin.Amount, coinId))
+			spentAmount += coin.Amount
+		}
+	}
+
+	//上次检查之后收到并且已经花费的coin
+	if newState.Height > state.Height {
+		puzzleHash, err := AddressToPuzzleHash(watchAddress.Address, config.GetConfig().AddressWatch.Prefix)
+		if err != nil {
+			return false, err
+		}
+		allRecords, err := blockChain.GetCoinRecordsByPuzzleHash(puzzleHash, true, state.Height+1)
+		if err != nil {
+			return false, err
+		}
+		if !allRecords.Success {
+			return false, errors.Errorf("get coin records failed: %s", allRecords.Error)
+		}
+		for _, coinRecord := range allRecords.CoinRecords {
+			if !coinRecord.Spent || coinRecord.ConfirmedBlockIndex <= state.Height {
+				continue
+			}
+			coinId, err := coinRecord.Coin.Id()
+			if err != nil {
+				return false, err
+			}
+			incoming = append(incoming, fmt.Sprintf("%s XCH，确认高度：%d，coin：%s", coinRecord.Coin.Amount, coinRecord.ConfirmedBlockIndex, coinId))
+			incomingAmount += coinRecord.Coin.Amount
+			spent = append(spent, fmt.Sprintf("%s XCH，花费高度：%d，coin：%s", coinRecord.Coin.Amount, coinRecord.SpentBlockIndex, coinId))
+			spentAmount += coinRecord.Coin.Amount
+		}
+	}
+
+	//获取配置文件
+	cfg := config.GetConfig()
+	machineName := cfg.Monitor.MachineName
+	event := "地址观察"
+	if len(incoming) > 0 {
+		detail := fmt.Sprintf("%s %s 收到%d个coin，共%s XCH%s，当前余额：%s XCH\n%s",
+			watchAddress.Name, watchAddress.Address, len(incoming), incomingAmount, fiatSuffix(blockChain.ctx, incomingAmount),
+			newState.Balance, joinLimited(incoming, watchNoticeMaxCoins))
+		wechat.SendChiaMonitorNoticeToWechat(machineName, event, detail, "观察地址收到转账")
+	}
+	if len(spent) > 0 {
+		detail := fmt.Sprintf("%s %s 花费%d个coin，共%s XCH%s，当前余额：%s XCH\n%s",
+			watchAddress.Name, watchAddress.Address, len(spent), spentAmount, fiatSuffix(blockChain.ctx, spentAmount),
+			newState.Balance, joinLimited(spent, watchNoticeMaxCoins))
+		wechat.SendCriticalNoticeToWechat(machineName, event, detail, "观察地址发生花费，请确认是否为本人操作")
+	}
+
+	return len(incoming) > 0 || len(spent) > 0, nil
+}
+
+// joinLimited 按行拼接，超过limit行时省略剩余部分
+func joinLimited(lines []string, limit int) string {
+	if len(lines) <= limit {
+		return strings.Join(lines, "\n")
+	}
+	return strings.Join(lines[:limit], "\n") + fmt.Sprintf("\n...等%d个", len(lines))
+}
@@ -0,0 +1,74 @@
+package chia
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"chia_monitor/src/bech32"
+	"chia_monitor/src/mojo"
+)
+
+func TestCoinId(t *testing.T) {
+	const parentCoinInfo = "0xccd5bb71183532bff220ba46c268991a3ff07eb358e8255a65c30a2dce0e5fbb"
+	const puzzleHash = "0x4bc6435b409bcbabe53870dae0f03755f6aabb4594c5915ec983acf2dce5fb14"
+	//amount按clvm整数编码，最高位为1时需要补0x00
+	tests := []struct {
+		amount mojo.Amount
+		want   string
+	}{
+		{0, "0x1ea5385ddcaa90a5a264088154fd40f5c5967adb32d7250967210d0d62f06878"},
+		{1, "0x561c47acb5225e2b19cad17f9e6f80193914ea3362c8ffa88717d678d361ccc1"},
+		{127, "0x66e93bb0ee11aeb673f6262f459a4ba095f3c5cd3294ff48ab6266966f4b1906"},
+		{128, "0x3d3a2d7162f020f0af760a41323414f48f2f449f00336aa239c3002b86fd527f"},
+		{255, "0xfb6ece03dfbb233a8b282c95052880268d93f791e7ade7eb17115e9e4d9a6315"},
+		{1750000000000, "0x0587ff3243c028484542349a99bca29bdd32ede52dd31af896059baf323c2c58"},
+		{18446744073709551615, "0xc50783fd75dbfeb1af18aa66b7719d2c4e2f1d04e4e02738a9b1ed5f2beb8135"},
+	}
+	for _, tt := range tests {
+		coin := Coin{Amount: tt.amount, ParentCoinInfo: parentCoinInfo, PuzzleHash: puzzleHash}
+		got, err := coin.Id()
+		if err != nil {
+			t.Errorf("Coin{Amount: %d}.Id() err = %v", tt.amount, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Coin{Amount: %d}.Id() = %s, want %s", tt.amount, got, tt.want)
+		}
+	}
+
+	//不带0x前缀时结果相同，非hex时返回错误
+	coin := Coin{Amount: 1, ParentCoinInfo: parentCoinInfo[2:], PuzzleHash: puzzleHash[2:]}
+	if got, err := coin.Id(); err != nil || got != tests[1].want {
+		t.Errorf("Coin.Id() without 0x prefix = %s, %v, want %s", got, err, tests[1].want)
+	}
+	coin.ParentCoinInfo = "0xzz"
+	if _, err := coin.Id(); err == nil {
+		t.Error("Coin.Id() with invalid parent coin info err = nil, want error")
+	}
+}
+
+func TestAddressToPuzzleHash(t *testing.T) {
+	//BIP-350中32字节的见证程序作为puzzle hash
+	puzzleHash := "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	data, _ := hex.DecodeString(puzzleHash)
+	address, err := bech32.Encode("xch", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := AddressToPuzzleHash(address, "xch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0x" + puzzleHash; got != want {
+		t.Errorf("AddressToPuzzleHash() = %s, want %s", got, want)
+	}
+
+	//测试网地址不能在主网观察
+	testnetAddress, err := bech32.Encode("txch", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddressToPuzzleHash(testnetAddress, "xch"); err == nil {
+		t.Errorf("AddressToPuzzleHash(%s, xch) err = nil, want error", testnetAddress)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"chia_monitor/src/monitor"
+	"chia_monitor/src/wechat"
+)
+
+// command 子命令
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+// checkTimeout 一次性检查的超时时间
+const checkTimeout = 2 * time.Minute
+
+// notifiers 可用的通知渠道，发送失败时返回错误
+var notifiers = map[string]func(machineName, event, detail, remark string) error{
+	"wechat": wechat.SendTestNotice,
+}
+
+// dailyReports 每日报告包含的监控，按顺序输出
+var dailyReports = []string{"walletReport", "poolReport", "poolEarning"}
+
+// commands 所有子命令
+func commands() []command {
+	return []command{
+		{"run", "run                               按调度持续运行所有监控（默认）", runDaemon},
+		{"status", "status [--json]                   检查所有启用的监控并输出结果", runStatus},
+		{"check", "check <monitor>                   检查单个监控，返回码与Nagios一致", runCheck},
+		{"health", "health [--format F] [monitor...]  检查一次后退出，返回码与Nagios一致，格式：text、nagios、json", runHealth},
+		{"test-notify", "test-notify [--channel X]         通过通知渠道发送测试消息", runTestNotify},
+		{"report", "report daily [--send]             生成每日报告，--send时发送通知", runReport},
+		{"check-config", "check-config                      检查配置文件", checkConfig},
+	}
+}
+
+// findCommand 按名称查找子命令
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// usage 输出命令行帮助
+func usage() {
+	output := flag.CommandLine.Output()
+	fmt.Fprintf(output, "Usage: %s [flags] <command> [args]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands() {
+		fmt.Fprintf(output, "  %s\n", cmd.usage)
+	}
+	fmt.Fprintf(output, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// runStatus 并发检查所有启用的监控，以表格或JSON输出
+func runStatus(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	asJson := flags.Bool("json", false, "以JSON格式输出")
+	_ = flags.Parse(args)
+
+	cfg := setup(false)
+	registry := newRegistry(cfg)
+	var monitors []monitor.Monitor
+	for _, m := range registry.Monitors() {
+		if registry.Enabled(m.Name()) {
+			monitors = append(monitors, m)
+		}
+	}
+	results := checkAll(monitors, checkTimeout)
+
+	if *asJson {
+		return printJson(results)
+	}
+	printTable(results)
+	return 0
+}
+
+// runCheck 检查单个监控并输出详情，返回码为监控状态
+func runCheck(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: check <monitor>")
+		return int(monitor.StatusUnknown)
+	}
+	cfg := setup(false)
+	registry := newRegistry(cfg)
+	m, ok := registry.Get(args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown monitor: %s, available: %s\n", args[0], strings.Join(monitorNames(registry), ", "))
+		return int(monitor.StatusUnknown)
+	}
+
+	result := checkAll([]monitor.Monitor{m}, checkTimeout)[0]
+	fmt.Printf("%s %s: %s\n", result.Monitor, result.Status, result.Summary)
+	if result.Detail != "" {
+		fmt.Println(result.Detail)
+	}
+	keys := make([]string, 0, len(result.Metrics))
+	for key := range result.Metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%s=%g\n", key, result.Metrics[key])
+	}
+	return int(result.Status)
+}
+
+// runHealth 检查一次所有启用的监控或指定的监控后退出，供cron或其他调度器使用，
+// 返回码与Nagios一致：0 OK、1 WARNING、2 CRITICAL、3 UNKNOWN
+func runHealth(args []string) int {
+	flags := flag.NewFlagSet("health", flag.ExitOnError)
+	format := flags.String("format", "text", "输出格式：text、nagios、json")
+	timeout := flags.Duration("timeout", checkTimeout, "检查超时时间，超时的监控为UNKNOWN")
+	_ = flags.Parse(args)
+	if *format != "text" && *format != "nagios" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown format: %s, available: text, nagios, json\n", *format)
+		return int(monitor.StatusUnknown)
+	}
+
+	cfg := setup(false)
+	registry := newRegistry(cfg)
+	var monitors []monitor.Monitor
+	if flags.NArg() > 0 {
+		for _, name := range flags.Args() {
+			m, ok := registry.Get(name)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Unknown monitor: %s, available: %s\n", name, strings.Join(monitorNames(registry), ", "))
+				return int(monitor.StatusUnknown)
+			}
+			monitors = append(monitors, m)
+		}
+	} else {
+		for _, m := range registry.Monitors() {
+			if registry.Enabled(m.Name()) {
+				monitors = append(monitors, m)
+			}
+		}
+	}
+	results := checkAll(monitors, *timeout)
+	status := monitor.Overall(results)
+
+	switch *format {
+	case "nagios":
+		fmt.Print(monitor.Nagios("CHIA", results))
+	case "json":
+		printJson(struct {
+			Status  monitor.Status   `json:"status"`
+			Results []monitor.Result `json:"results"`
+		}{status, results})
+	default:
+		printTable(results)
+		fmt.Printf("Overall: %s\n", status)
+	}
+	return int(status)
+}
+
+// runTestNotify 通过指定的通知渠道同步发送测试消息
+func runTestNotify(args []string) int {
+	flags := flag.NewFlagSet("test-notify", flag.ExitOnError)
+	channel := flags.String("channel", "wechat", "通知渠道")
+	_ = flags.Parse(args)
+
+	notify, ok := notifiers[*channel]
+	if !ok {
+		var channels []string
+		for name := range notifiers {
+			channels = append(channels, name)
+		}
+		sort.Strings(channels)
+		fmt.Fprintf(os.Stderr, "Unknown channel: %s, available: %s\n", *channel, strings.Join(channels, ", "))
+		return 2
+	}
+	cfg := setup(false)
+	err := notify(cfg.Monitor.MachineName, "测试通知", "这是一条测试消息，收到说明通知配置正确", "通过test-notify发送")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Send test notice through %s failed: %s\n", *channel, err)
+		return 1
+	}
+	if cfg.DryRun.Enabled && cfg.DryRun.SuppressNotice {
+		fmt.Println("Dry-run mode suppresses notices, test notice is only logged")
+		return 0
+	}
+	fmt.Printf("Send test notice through %s success\n", *channel)
+	return 0
+}
+
+// runReport 生成报告，默认只输出，--send时按定时任务的方式执行并发送通知
+func runReport(args []string) int {
+	if len(args) == 0 || args[0] != "daily" {
+		fmt.Fprintln(os.Stderr, "Usage: report daily [--send]")
+		return 2
+	}
+	flags := flag.NewFlagSet("report daily", flag.ExitOnError)
+	send := flags.Bool("send", false, "发送通知")
+	_ = flags.Parse(args[1:])
+
+	cfg := setup(false)
+	registry := newRegistry(cfg)
+	var monitors []monitor.Monitor
+	for _, name := range dailyReports {
+		if m, ok := registry.Get(name); ok {
+			monitors = append(monitors, m)
+		}
+	}
+
+	var results []monitor.Result
+	if *send {
+		for _, m := range monitors {
+			results = append(results, registry.Run(context.Background(), m))
+		}
+		if !wechat.Flush(shutdownTimeout) {
+			fmt.Fprintln(os.Stderr, "Some notices are not sent")
+		}
+	} else {
+		results = checkAll(monitors, checkTimeout)
+	}
+	for _, result := range results {
+		fmt.Printf("【%s】%s %s\n", result.Monitor, result.Status, result.Summary)
+		if result.Detail != "" {
+			fmt.Println(result.Detail)
+		}
+		fmt.Println()
+	}
+	return 0
+}
+
+// checkAll 并发检查监控，结果与监控顺序一致，检查panic时为Unknown，超时未完成的检查为Unknown
+func checkAll(monitors []monitor.Monitor, timeout time.Duration) []monitor.Result {
+	type indexed struct {
+		index  int
+		result monitor.Result
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	//带缓冲，超时后仍在执行的检查结束时不会阻塞
+	done := make(chan indexed, len(monitors))
+	for i, m := range monitors {
+		go func(i int, m monitor.Monitor) {
+			defer func() {
+				if err := recover(); err != nil {
+					done <- indexed{i, monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "监控执行异常", fmt.Errorf("panic: %v", err))}
+				}
+			}()
+			done <- indexed{i, m.Check(ctx)}
+		}(i, m)
+	}
+
+	results := make([]monitor.Result, len(monitors))
+	finished := make([]bool, len(monitors))
+	for range monitors {
+		select {
+		case r := <-done:
+			results[r.index] = r.result
+			finished[r.index] = true
+		case <-ctx.Done():
+			for i, m := range monitors {
+				if !finished[i] {
+					results[i] = monitor.ErrorResult(m.Name(), monitor.StatusUnknown, "检查超时", ctx.Err())
+				}
+			}
+			return results
+		}
+	}
+	return results
+}
+
+// printTable 以表格输出检查结果
+func printTable(results []monitor.Result) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "MONITOR\tSTATUS\tSUMMARY")
+	for _, result := range results {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", result.Monitor, result.Status, result.Summary)
+	}
+	_ = writer.Flush()
+}
+
+// printJson 以JSON格式输出
+func printJson(v interface{}) int {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// monitorNames 所有注册的监控名称
+func monitorNames(registry *monitor.Registry) []string {
+	var names []string
+	for _, m := range registry.Monitors() {
+		names = append(names, m.Name())
+	}
+	return names
+}
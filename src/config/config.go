@@ -3,14 +3,22 @@ package config
 import (
 	"flag"
 	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"time"
 
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 )
 
 var (
 	cfgFile = flag.String("c", "./config.yaml", "配置文件路径")
 
-	cfg *Config
+	cfgLock   sync.RWMutex
+	cfg       *Config
+	cfgMod    time.Time       //已加载的配置文件修改时间
+	overrides []func(*Config) //每次加载配置后执行，用于命令行参数覆盖配置
 )
 
 // LogConfig 日志相关配置
@@ -45,6 +53,8 @@ type WalletCertPath struct {
 type Monitor struct {
 	MachineName          string   `yaml:"machineName"`
 	BockChainInterval    int      `yaml:"blockChainInterval"`
+	SyncStallCount       int      `yaml:"syncStallCount"`    //同步进度连续多少次检查没有变化时重启
+	PeakMaxAgeMinutes    int      `yaml:"peakMaxAgeMinutes"` //最新交易区块时间超过多少分钟时通知
 	FarmerInterval       int      `yaml:"farmerInterval"`
 	DailyCron            string   `yaml:"dailyCron"`
 	HarvesterList        []string `yaml:"harvesterList"`
@@ -54,31 +64,474 @@ type Monitor struct {
 	PoolName             string   `yaml:"poolName"`
 }
 
+// LedgerConfig 收益账本配置
+type LedgerConfig struct {
+	DbPath      string `yaml:"dbPath"`      //收益账本文件路径
+	MissingDays int    `yaml:"missingDays"` //检测最近多少天缺失的收益
+}
+
+// PriceConfig 法币价格配置
+type PriceConfig struct {
+	Provider        string             `yaml:"provider"`        //价格来源：http、static，为空时不换算法币
+	Currencies      []string           `yaml:"currencies"`      //换算的法币，如：CNY、USD
+	Url             string             `yaml:"url"`             //http价格接口地址，{currency}替换为小写币种代码
+	JsonPath        string             `yaml:"jsonPath"`        //价格在返回json中的路径，以.分隔
+	Static          map[string]float64 `yaml:"static"`          //手动配置的价格
+	CacheMinutes    int                `yaml:"cacheMinutes"`    //价格缓存时间
+	MaxStaleMinutes int                `yaml:"maxStaleMinutes"` //价格来源不可用时，缓存价格最长可以使用多久
+}
+
+// WalletRule 单个钱包的监控规则，按钱包id或名称匹配
+type WalletRule struct {
+	Id                int    `yaml:"id"`                //钱包id
+	Name              string `yaml:"name"`              //钱包名称，id为0时按名称匹配
+	MinBalance        string `yaml:"minBalance"`        //最低余额，低于该余额时通知，单位：XCH或CAT
+	ChangeAlert       bool   `yaml:"changeAlert"`       //余额变化时通知
+	MaxDrop           string `yaml:"maxDrop"`           //两次检查之间可用余额（含待确认找零）减少超过本钱包转出交易的数量上限，超过时发送严重通知
+	PendingMaxMinutes int    `yaml:"pendingMaxMinutes"` //未确认余额与已确认余额不一致的最长时间，单位：分钟
+	TargetBalance     string `yaml:"targetBalance"`     //目标余额，余额越过目标余额时通知
+}
+
+// WalletMonitor 钱包监控配置
+type WalletMonitor struct {
+	Interval     int          `yaml:"interval"`     //钱包检查间隔
+	TxStateFile  string       `yaml:"txStateFile"`  //已通知交易记录文件
+	TxPageSize   int          `yaml:"txPageSize"`   //每次获取最近的交易数量
+	MaxHeightLag int          `yaml:"maxHeightLag"` //钱包最多允许落后全节点的区块数
+	Wallets      []WalletRule `yaml:"wallets"`      //各个钱包的监控规则
+}
+
+// GetWalletRule 获取钱包的监控规则，未配置时返回nil
+func (w *WalletMonitor) GetWalletRule(id int, name string) *WalletRule {
+	for i, rule := range w.Wallets {
+		if (rule.Id != 0 && rule.Id == id) || (rule.Id == 0 && rule.Name != "" && rule.Name == name) {
+			return &w.Wallets[i]
+		}
+	}
+	return nil
+}
+
+// WatchAddress 观察的地址
+type WatchAddress struct {
+	Name    string `yaml:"name"`    //地址名称，如：冷钱包、矿池收款地址
+	Address string `yaml:"address"` //xch1...地址
+}
+
+// AddressWatch 地址观察配置，通过全节点的coin记录监控地址余额，不需要运行钱包
+type AddressWatch struct {
+	Interval  int            `yaml:"interval"`  //检查间隔
+	StateFile string         `yaml:"stateFile"` //已知coin记录文件
+	Prefix    string         `yaml:"prefix"`    //地址前缀，主网为xch，测试网为txch
+	Addresses []WatchAddress `yaml:"addresses"` //观察的地址
+}
+
+// ConnectionMonitor 全节点连接监控配置
+type ConnectionMonitor struct {
+	MinFullNodePeers int      `yaml:"minFullNodePeers"` //全节点连接数低于该值时通知
+	RestartAfter     int      `yaml:"restartAfter"`     //连续多少次检查连接数不足后重启，之前先尝试连接trustedPeers
+	TrustedPeers     []string `yaml:"trustedPeers"`     //连接数不足时主动连接的节点，格式：host:port
+}
+
+// ForkDetection 链重组及分叉检测配置
+type ForkDetection struct {
+	HistoryBlocks     int    `yaml:"historyBlocks"`     //记录最近多少个区块的header hash
+	ReorgAlertDepth   int    `yaml:"reorgAlertDepth"`   //重组深度达到该值时通知
+	ForkDepth         int    `yaml:"forkDepth"`         //重组深度达到该值时视为分叉，发送严重通知
+	ReferenceRpcUrl   string `yaml:"referenceRpcUrl"`   //参考全节点RPC接口，为空时不比较
+	ReferenceCertPath string `yaml:"referenceCertPath"` //参考全节点证书
+	ReferenceKeyPath  string `yaml:"referenceKeyPath"`  //参考全节点证书私钥
+	ConfirmBlocks     int    `yaml:"confirmBlocks"`     //与参考节点比较时，比较最新区块之前多少个区块，避免最新区块的正常差异
+	DivergenceCount   int    `yaml:"divergenceCount"`   //与参考节点连续多少次不一致时视为分叉
+}
+
+// MempoolMonitor 内存池监控配置
+type MempoolMonitor struct {
+	Interval        int     `yaml:"interval"`        //检查间隔
+	CongestionRatio float64 `yaml:"congestionRatio"` //内存池cost占最大cost的比例达到该值时视为拥堵
+	MaxTxCount      int     `yaml:"maxTxCount"`      //内存池交易数达到该值时视为拥堵，0为不检查
+	SustainedCount  int     `yaml:"sustainedCount"`  //连续多少次检查拥堵时通知
+	TargetTimes     []int   `yaml:"targetTimes"`     //手续费预估的目标确认时间，单位：秒
+	HistoryHours    int     `yaml:"historyHours"`    //保留多少小时的内存池状态，用于统计峰值和趋势
+}
+
+// RemediationAction 修复操作，命令、主机等支持{变量}，如收割机掉线时的{harvester}
+type RemediationAction struct {
+	Name         string   `yaml:"name"`         //操作名称，在escalations中引用
+	Type         string   `yaml:"type"`         //操作类型：restartChia、restartService、ssh、script、refreshPlots
+	Service      string   `yaml:"service"`      //restartService重启的服务，如：chia_full_node
+	Host         string   `yaml:"host"`         //ssh主机
+	Port         int      `yaml:"port"`         //ssh端口
+	User         string   `yaml:"user"`         //ssh用户
+	IdentityFile string   `yaml:"identityFile"` //ssh私钥
+	Command      string   `yaml:"command"`      //ssh远程执行的命令或script执行的脚本
+	Args         []string `yaml:"args"`         //命令参数
+}
+
+// Remediation 自动修复配置
+type Remediation struct {
+	RestartCommand        string   `yaml:"restartCommand"`        //重启chia的命令
+	RestartArgs           []string `yaml:"restartArgs"`           //重启命令参数
+	RestartDir            string   `yaml:"restartDir"`            //重启命令的工作目录
+	RestartEnv            []string `yaml:"restartEnv"`            //重启命令额外的环境变量，格式：KEY=VALUE
+	RestartTimeoutMinutes int      `yaml:"restartTimeoutMinutes"` //重启命令超时时间
+	BackoffBaseMinutes    int      `yaml:"backoffBaseMinutes"`    //连续重启的初始间隔，之后每次翻倍
+	BackoffMaxMinutes     int      `yaml:"backoffMaxMinutes"`     //连续重启的最大间隔
+	MaxRestarts           int      `yaml:"maxRestarts"`           //时间窗口内最多重启次数，超过后停止重启并发送严重通知
+	RestartWindowMinutes  int      `yaml:"restartWindowMinutes"`  //重启次数统计的时间窗口
+	DaemonUrl             string   `yaml:"daemonUrl"`             //守护进程websocket地址
+	DaemonCertPath        string   `yaml:"daemonCertPath"`        //守护进程证书
+	DaemonKeyPath         string   `yaml:"daemonKeyPath"`         //守护进程证书私钥
+	HarvesterRpcUrl       string   `yaml:"harvesterRpcUrl"`       //收割机rpc地址
+	HarvesterCertPath     string   `yaml:"harvesterCertPath"`     //收割机证书
+	HarvesterKeyPath      string   `yaml:"harvesterKeyPath"`      //收割机证书私钥
+
+	Actions     []RemediationAction `yaml:"actions"`     //修复操作，restartChia为内置操作
+	Escalations map[string][]string `yaml:"escalations"` //各故障条件依次执行的修复操作
+}
+
+// Wechat 微信通知配置，支持通过环境变量或file://引用密钥文件配置
+type Wechat struct {
+	PostUrl string `yaml:"postUrl"` //通知中转接口地址
+	Account string `yaml:"account"` //接收通知的微信账号
+}
+
+// DryRun 演练模式配置，用于在生产环境测试新配置
+type DryRun struct {
+	Enabled        bool `yaml:"enabled"`        //是否开启演练模式，开启后修复操作及删除文件只记录日志不执行
+	SuppressNotice bool `yaml:"suppressNotice"` //演练模式下是否不发送通知，只记录日志
+}
+
+// MonitorSchedule 单个监控的开关及调度，未配置时启用并使用默认调度
+type MonitorSchedule struct {
+	Enabled  *bool  `yaml:"enabled"`  //是否启用，默认启用
+	Interval int    `yaml:"interval"` //执行间隔，单位：分钟
+	Cron     string `yaml:"cron"`     //cron表达式，配置后忽略interval
+}
+
 // Config 配置文件结构体
 type Config struct {
-	Listen            string `yaml:"listen"` //监听本地的端口
-	*LogConfig        `yaml:"logConfig"`
-	*Coin             `yaml:"coin"`
-	*FullNodeCertPath `yaml:"fullNodeCertPath"`
-	*WalletCertPath   `yaml:"walletCertPath"`
-	*Monitor          `yaml:"monitor"`
+	Listen             string `yaml:"listen"`         //状态接口监听地址，如：127.0.0.1:9090，为空时不启动
+	ApiToken           string `yaml:"apiToken"`       //状态接口的Bearer Token，为空时不校验，/healthz不校验
+	PidFile            string `yaml:"pidFile"`        //pid文件，stop.sh根据pid文件停止进程
+	ReloadInterval     int    `yaml:"reloadInterval"` //检查配置文件是否修改的间隔，单位：秒，修改后自动重新加载
+	*LogConfig         `yaml:"logConfig"`
+	*Coin              `yaml:"coin"`
+	*FullNodeCertPath  `yaml:"fullNodeCertPath"`
+	*WalletCertPath    `yaml:"walletCertPath"`
+	*Monitor           `yaml:"monitor"`
+	*LedgerConfig      `yaml:"ledgerConfig"`
+	*PriceConfig       `yaml:"priceConfig"`
+	*WalletMonitor     `yaml:"walletMonitor"`
+	*AddressWatch      `yaml:"addressWatch"`
+	*ConnectionMonitor `yaml:"connectionMonitor"`
+	*ForkDetection     `yaml:"forkDetection"`
+	*MempoolMonitor    `yaml:"mempoolMonitor"`
+	*Remediation       `yaml:"remediation"`
+	*DryRun            `yaml:"dryRun"`
+	*Wechat            `yaml:"wechat"`
+
+	Monitors map[string]*MonitorSchedule `yaml:"monitors"` //各监控的开关及调度，键为监控名称
 }
 
 //GetConfig 获取配置
 func GetConfig() *Config {
+	cfgLock.RLock()
+	current := cfg
+	cfgLock.RUnlock()
+	if current != nil {
+		return current
+	}
+
+	cfgLock.Lock()
+	defer cfgLock.Unlock()
 	if cfg != nil {
 		return cfg
 	}
-	bytes, err := ioutil.ReadFile(*cfgFile)
+	cfgData, modTime, err := load()
 	if err != nil {
 		panic(err)
 	}
+	cfg, cfgMod = cfgData, modTime
+	return cfg
+}
+
+//Init 加载并校验配置文件，需要在flag.Parse之后调用，使-c参数生效
+func Init() error {
+	cfgData, modTime, err := load()
+	if err != nil {
+		return err
+	}
+	if err = cfgData.Validate(); err != nil {
+		return err
+	}
+	cfgLock.Lock()
+	defer cfgLock.Unlock()
+	cfg, cfgMod = cfgData, modTime
+	return nil
+}
+
+//Load 读取配置文件，不替换当前配置，用于检查配置
+func Load() (*Config, error) {
+	cfgData, _, err := load()
+	return cfgData, err
+}
+
+//Path 配置文件路径
+func Path() string {
+	return *cfgFile
+}
+
+//Reload 重新加载配置文件，校验通过后替换当前配置，失败时保留当前配置，返回新旧配置
+func Reload() (*Config, *Config, error) {
+	cfgData, modTime, err := load()
+	if err == nil {
+		err = cfgData.Validate()
+	}
+	if err != nil {
+		//记录修改时间，避免解析或校验失败时未修正前反复加载
+		if !modTime.IsZero() {
+			cfgLock.Lock()
+			cfgMod = modTime
+			cfgLock.Unlock()
+		}
+		return nil, nil, err
+	}
+
+	cfgLock.Lock()
+	defer cfgLock.Unlock()
+	old := cfg
+	cfg, cfgMod = cfgData, modTime
+	return old, cfgData, nil
+}
+
+//Changed 配置文件修改时间是否与已加载的不一致
+func Changed() bool {
+	info, err := os.Stat(*cfgFile)
+	if err != nil {
+		return false
+	}
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+	return !info.ModTime().Equal(cfgMod)
+}
+
+//AddOverride 添加配置覆盖，立即作用于当前配置，之后每次重新加载配置时也会执行
+func AddOverride(override func(*Config)) {
+	cfgLock.Lock()
+	defer cfgLock.Unlock()
+	overrides = append(overrides, override)
+	if cfg != nil {
+		override(cfg)
+	}
+}
+
+//load 读取并解析配置文件
+func load() (*Config, time.Time, error) {
+	info, err := os.Stat(*cfgFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	bytes, err := ioutil.ReadFile(*cfgFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
 
 	cfgData := &Config{}
 	err = yaml.Unmarshal(bytes, cfgData)
 	if err != nil {
-		panic(err)
+		return nil, info.ModTime(), errors.Wrapf(err, "parse %s", *cfgFile)
+	}
+	//环境变量覆盖配置文件，之后读取file://引用的密钥
+	if err = applyEnv(cfgData); err != nil {
+		return nil, info.ModTime(), err
+	}
+	if err = resolveSecrets(reflect.ValueOf(cfgData)); err != nil {
+		return nil, info.ModTime(), err
+	}
+	setDefault(cfgData)
+	for _, override := range overrides {
+		override(cfgData)
+	}
+	return cfgData, info.ModTime(), nil
+}
+
+//IsMonitorEnabled 监控是否启用，未配置时默认启用
+func (c *Config) IsMonitorEnabled(name string) bool {
+	schedule, ok := c.Monitors[name]
+	if !ok || schedule == nil || schedule.Enabled == nil {
+		return true
+	}
+	return *schedule.Enabled
+}
+
+//setDefault 未配置的可选项使用默认值
+func setDefault(cfgData *Config) {
+	if cfgData.PidFile == "" {
+		cfgData.PidFile = "./chia_monitor.pid"
+	}
+	if cfgData.ReloadInterval <= 0 {
+		cfgData.ReloadInterval = 30
+	}
+	if cfgData.Monitor == nil {
+		cfgData.Monitor = &Monitor{}
+	}
+	if cfgData.Monitor.SyncStallCount <= 0 {
+		cfgData.Monitor.SyncStallCount = 6
+	}
+	if cfgData.Monitor.PeakMaxAgeMinutes <= 0 {
+		cfgData.Monitor.PeakMaxAgeMinutes = 10
+	}
+
+	if cfgData.LedgerConfig == nil {
+		cfgData.LedgerConfig = &LedgerConfig{}
+	}
+	if cfgData.LedgerConfig.DbPath == "" {
+		cfgData.LedgerConfig.DbPath = "./data/earnings.json"
+	}
+	if cfgData.LedgerConfig.MissingDays <= 0 {
+		cfgData.LedgerConfig.MissingDays = 7
+	}
+
+	if cfgData.PriceConfig == nil {
+		cfgData.PriceConfig = &PriceConfig{}
+	}
+	if len(cfgData.PriceConfig.Currencies) == 0 {
+		cfgData.PriceConfig.Currencies = []string{"CNY", "USD"}
+	}
+	if cfgData.PriceConfig.CacheMinutes <= 0 {
+		cfgData.PriceConfig.CacheMinutes = 10
+	}
+	if cfgData.PriceConfig.MaxStaleMinutes <= 0 {
+		cfgData.PriceConfig.MaxStaleMinutes = 120
+	}
+
+	if cfgData.WalletMonitor == nil {
+		cfgData.WalletMonitor = &WalletMonitor{}
+	}
+	if cfgData.WalletMonitor.Interval <= 0 {
+		cfgData.WalletMonitor.Interval = 5
+	}
+	if cfgData.WalletMonitor.TxStateFile == "" {
+		cfgData.WalletMonitor.TxStateFile = "./data/wallet_tx_state.json"
+	}
+	if cfgData.WalletMonitor.TxPageSize <= 0 {
+		cfgData.WalletMonitor.TxPageSize = 50
+	}
+	if cfgData.WalletMonitor.MaxHeightLag <= 0 {
+		cfgData.WalletMonitor.MaxHeightLag = 20
+	}
+
+	if cfgData.AddressWatch == nil {
+		cfgData.AddressWatch = &AddressWatch{}
+	}
+	if cfgData.AddressWatch.Interval <= 0 {
+		cfgData.AddressWatch.Interval = 10
+	}
+	if cfgData.AddressWatch.StateFile == "" {
+		cfgData.AddressWatch.StateFile = "./data/address_watch_state.json"
+	}
+	if cfgData.AddressWatch.Prefix == "" {
+		cfgData.AddressWatch.Prefix = "xch"
+	}
+
+	if cfgData.ConnectionMonitor == nil {
+		cfgData.ConnectionMonitor = &ConnectionMonitor{}
+	}
+	if cfgData.ConnectionMonitor.MinFullNodePeers <= 0 {
+		cfgData.ConnectionMonitor.MinFullNodePeers = 3
+	}
+	if cfgData.ConnectionMonitor.RestartAfter <= 0 {
+		cfgData.ConnectionMonitor.RestartAfter = 3
+	}
+
+	if cfgData.ForkDetection == nil {
+		cfgData.ForkDetection = &ForkDetection{}
+	}
+	if cfgData.ForkDetection.HistoryBlocks <= 0 {
+		cfgData.ForkDetection.HistoryBlocks = 100
+	}
+	if cfgData.ForkDetection.ReorgAlertDepth <= 0 {
+		cfgData.ForkDetection.ReorgAlertDepth = 3
+	}
+	if cfgData.ForkDetection.ForkDepth <= 0 {
+		cfgData.ForkDetection.ForkDepth = 10
+	}
+	if cfgData.ForkDetection.ConfirmBlocks <= 0 {
+		cfgData.ForkDetection.ConfirmBlocks = 6
+	}
+	if cfgData.ForkDetection.DivergenceCount <= 0 {
+		cfgData.ForkDetection.DivergenceCount = 3
+	}
+
+	if cfgData.MempoolMonitor == nil {
+		cfgData.MempoolMonitor = &MempoolMonitor{}
+	}
+	if cfgData.MempoolMonitor.Interval <= 0 {
+		cfgData.MempoolMonitor.Interval = 5
+	}
+	if cfgData.MempoolMonitor.CongestionRatio <= 0 {
+		cfgData.MempoolMonitor.CongestionRatio = 0.8
+	}
+	if cfgData.MempoolMonitor.SustainedCount <= 0 {
+		cfgData.MempoolMonitor.SustainedCount = 3
+	}
+	if len(cfgData.MempoolMonitor.TargetTimes) == 0 {
+		cfgData.MempoolMonitor.TargetTimes = []int{60, 300, 600}
+	}
+	if cfgData.MempoolMonitor.HistoryHours <= 0 {
+		cfgData.MempoolMonitor.HistoryHours = 24
+	}
+
+	if cfgData.Wechat == nil {
+		cfgData.Wechat = &Wechat{}
+	}
+	if cfgData.Wechat.PostUrl == "" {
+		cfgData.Wechat.PostUrl = "https://test.wechat.yasin.store/api/v1/online/send_chia_monitor_message"
+	}
+
+	if cfgData.DryRun == nil {
+		cfgData.DryRun = &DryRun{}
+	}
+	if cfgData.Remediation == nil {
+		cfgData.Remediation = &Remediation{}
+	}
+	if cfgData.Remediation.RestartCommand == "" {
+		cfgData.Remediation.RestartCommand = "/root/restart.sh"
+	}
+	if cfgData.Remediation.RestartDir == "" {
+		cfgData.Remediation.RestartDir = "/root"
+	}
+	if cfgData.Remediation.RestartTimeoutMinutes <= 0 {
+		cfgData.Remediation.RestartTimeoutMinutes = 10
+	}
+	if cfgData.Remediation.BackoffBaseMinutes <= 0 {
+		cfgData.Remediation.BackoffBaseMinutes = 20
+	}
+	if cfgData.Remediation.BackoffMaxMinutes <= 0 {
+		cfgData.Remediation.BackoffMaxMinutes = 240
+	}
+	if cfgData.Remediation.MaxRestarts <= 0 {
+		cfgData.Remediation.MaxRestarts = 3
+	}
+	if cfgData.Remediation.RestartWindowMinutes <= 0 {
+		cfgData.Remediation.RestartWindowMinutes = 360
+	}
+	if cfgData.Remediation.DaemonUrl == "" {
+		cfgData.Remediation.DaemonUrl = "wss://127.0.0.1:55400"
+	}
+	if cfgData.Remediation.DaemonCertPath == "" {
+		cfgData.Remediation.DaemonCertPath = "ssl/daemon/private_daemon.crt"
+	}
+	if cfgData.Remediation.DaemonKeyPath == "" {
+		cfgData.Remediation.DaemonKeyPath = "ssl/daemon/private_daemon.key"
+	}
+	if cfgData.Remediation.HarvesterRpcUrl == "" {
+		cfgData.Remediation.HarvesterRpcUrl = "https://127.0.0.1:8560/"
+	}
+	if cfgData.Remediation.HarvesterCertPath == "" {
+		cfgData.Remediation.HarvesterCertPath = "ssl/harvester/private_harvester.crt"
+	}
+	if cfgData.Remediation.HarvesterKeyPath == "" {
+		cfgData.Remediation.HarvesterKeyPath = "ssl/harvester/private_harvester.key"
 	}
-	cfg = cfgData
-	return cfg
 }
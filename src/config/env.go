@@ -0,0 +1,268 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix 环境变量前缀，如monitor.machineName对应CHIA_MONITOR_MONITOR_MACHINE_NAME
+const envPrefix = "CHIA_MONITOR"
+
+// fileSuffix 环境变量加上该后缀时，值为密钥文件路径，如CHIA_MONITOR_WECHAT_ACCOUNT_FILE
+const fileSuffix = "_FILE"
+
+// secretScheme 配置值以该前缀开头时从文件读取，如：file:///run/secrets/wechat_account
+const secretScheme = "file://"
+
+// applyEnv 使用环境变量覆盖配置，字符串直接赋值，其他类型按yaml解析，如：[a, b]、true、{key: value}
+func applyEnv(cfgData *Config) error {
+	return applyEnvToStruct(reflect.ValueOf(cfgData).Elem(), envPrefix)
+}
+
+// applyEnvToStruct 按yaml标签递归覆盖结构体字段，环境变量名为前缀加上各级键名
+func applyEnvToStruct(value reflect.Value, prefix string) error {
+	valueType := value.Type()
+	for i := 0; i < value.NumField(); i++ {
+		field := valueType.Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" || field.PkgPath != "" {
+			continue
+		}
+		name := prefix + "_" + envName(key)
+		fieldValue := value.Field(i)
+
+		//嵌套的配置段，未配置时创建，只有被环境变量覆盖时才保留
+		if fieldValue.Kind() == reflect.Ptr && fieldValue.Type().Elem().Kind() == reflect.Struct {
+			section := fieldValue
+			if section.IsNil() {
+				section = reflect.New(fieldValue.Type().Elem())
+			}
+			before := reflect.Indirect(section).Interface()
+			if err := applyEnvToStruct(section.Elem(), name); err != nil {
+				return err
+			}
+			if fieldValue.IsNil() && !reflect.DeepEqual(before, section.Elem().Interface()) {
+				fieldValue.Set(section)
+			}
+			continue
+		}
+		if fieldValue.Kind() == reflect.Struct {
+			if err := applyEnvToStruct(fieldValue, name); err != nil {
+				return err
+			}
+			continue
+		}
+		//以配置段为值的map，先按整个map覆盖，再覆盖单个键，如monitors.walletBalance.enabled对应CHIA_MONITOR_MONITORS_WALLET_BALANCE_ENABLED
+		if isSectionMap(fieldValue.Type()) {
+			if err := applyEnvToValue(fieldValue, name); err != nil {
+				return err
+			}
+			if err := applyEnvToMap(fieldValue, name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := applyEnvToValue(fieldValue, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnvToValue 使用环境变量覆盖单个值，字符串直接赋值，其他类型按yaml解析
+func applyEnvToValue(fieldValue reflect.Value, name string) error {
+	env, ok, err := lookupEnv(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if fieldValue.Kind() == reflect.String {
+		fieldValue.SetString(env)
+		return nil
+	}
+	parsed := reflect.New(fieldValue.Type())
+	if err = yaml.Unmarshal([]byte(env), parsed.Interface()); err != nil {
+		return errors.Wrapf(err, "parse env %s", name)
+	}
+	fieldValue.Set(parsed.Elem())
+	return nil
+}
+
+// isSectionMap 是否为以字符串为键、配置段为值的map
+func isSectionMap(mapType reflect.Type) bool {
+	if mapType.Kind() != reflect.Map || mapType.Key().Kind() != reflect.String {
+		return false
+	}
+	elemType := mapType.Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	return elemType.Kind() == reflect.Struct
+}
+
+// applyEnvToMap 覆盖map中单个键的配置，环境变量名为前缀加上键名及字段名，
+// 配置文件中没有的键按字段名从环境变量名中拆出，转换为驼峰命名，如WALLET_BALANCE转为walletBalance
+func applyEnvToMap(value reflect.Value, prefix string) error {
+	elemType := value.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+
+	//环境变量中的键名对应的map键
+	keys := make(map[string]string)
+	for _, key := range value.MapKeys() {
+		keys[envName(key.String())] = key.String()
+	}
+	for _, env := range os.Environ() {
+		envKey := strings.TrimSuffix(strings.SplitN(env, "=", 2)[0], fileSuffix)
+		if !strings.HasPrefix(envKey, prefix+"_") {
+			continue
+		}
+		rest := strings.TrimPrefix(envKey, prefix+"_")
+		for i := 0; i < structType.NumField(); i++ {
+			fieldKey := strings.Split(structType.Field(i).Tag.Get("yaml"), ",")[0]
+			suffix := "_" + envName(fieldKey)
+			if fieldKey == "" || fieldKey == "-" || len(rest) <= len(suffix) || !strings.HasSuffix(rest, suffix) {
+				continue
+			}
+			keyName := rest[:len(rest)-len(suffix)]
+			if _, ok := keys[keyName]; !ok {
+				keys[keyName] = camelName(keyName)
+			}
+		}
+	}
+
+	for keyName, key := range keys {
+		mapKey := reflect.ValueOf(key).Convert(value.Type().Key())
+		item := reflect.New(elemType).Elem()
+		if existing := value.MapIndex(mapKey); existing.IsValid() {
+			item.Set(existing)
+		}
+		section := item
+		if elemType.Kind() == reflect.Ptr {
+			section = reflect.New(structType)
+			if !item.IsNil() {
+				section.Elem().Set(item.Elem())
+			}
+			section = section.Elem()
+		}
+		before := section.Interface()
+		if err := applyEnvToStruct(section, prefix+"_"+keyName); err != nil {
+			return err
+		}
+		//只有被环境变量覆盖时才写入，不改变未覆盖的键
+		if reflect.DeepEqual(before, section.Interface()) {
+			continue
+		}
+		if elemType.Kind() == reflect.Ptr {
+			item = section.Addr()
+		} else {
+			item = section
+		}
+		if value.IsNil() {
+			value.Set(reflect.MakeMap(value.Type()))
+		}
+		value.SetMapIndex(mapKey, item)
+	}
+	return nil
+}
+
+// camelName 大写下划线命名转为驼峰命名，如：WALLET_BALANCE转为walletBalance
+func camelName(name string) string {
+	var builder strings.Builder
+	for i, word := range strings.Split(strings.ToLower(name), "_") {
+		if i > 0 && word != "" {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		builder.WriteString(word)
+	}
+	return builder.String()
+}
+
+// lookupEnv 获取环境变量，NAME_FILE存在时读取文件内容
+func lookupEnv(name string) (string, bool, error) {
+	if path, ok := os.LookupEnv(name + fileSuffix); ok {
+		secret, err := readSecret(path)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "env %s", name+fileSuffix)
+		}
+		return secret, true, nil
+	}
+	env, ok := os.LookupEnv(name)
+	return env, ok, nil
+}
+
+// envName 驼峰命名转为大写下划线命名，如：blockChainRpcUrl转为BLOCK_CHAIN_RPC_URL
+func envName(key string) string {
+	var builder strings.Builder
+	for i, r := range key {
+		if unicode.IsUpper(r) && i > 0 {
+			builder.WriteByte('_')
+		}
+		builder.WriteRune(unicode.ToUpper(r))
+	}
+	return builder.String()
+}
+
+// resolveSecrets 将配置中以file://开头的字符串替换为文件内容
+func resolveSecrets(value reflect.Value) error {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !value.IsNil() {
+			return resolveSecrets(value.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if err := resolveSecrets(value.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			if err := resolveSecrets(value.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		//map的值不可寻址，替换后重新设置
+		for _, key := range value.MapKeys() {
+			item := reflect.New(value.Type().Elem()).Elem()
+			item.Set(value.MapIndex(key))
+			if err := resolveSecrets(item); err != nil {
+				return err
+			}
+			value.SetMapIndex(key, item)
+		}
+	case reflect.String:
+		if !strings.HasPrefix(value.String(), secretScheme) {
+			return nil
+		}
+		secret, err := readSecret(strings.TrimPrefix(value.String(), secretScheme))
+		if err != nil {
+			return err
+		}
+		value.SetString(secret)
+	}
+	return nil
+}
+
+// readSecret 读取密钥文件，去掉首尾空白
+func readSecret(path string) (string, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "read secret file")
+	}
+	return strings.TrimSpace(string(bytes)), nil
+}
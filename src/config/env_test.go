@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func setEnv(t *testing.T, name, value string) {
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Unsetenv(name) })
+}
+
+func TestApplyEnvToMap(t *testing.T) {
+	enabled := true
+	cfgData := &Config{
+		Monitor: &Monitor{MachineName: "NAS"},
+		Monitors: map[string]*MonitorSchedule{
+			"watchAddresses": {Enabled: &enabled, Interval: 5},
+			"mempool":        nil,
+		},
+	}
+	//配置文件中已有的键
+	setEnv(t, "CHIA_MONITOR_MONITORS_WATCH_ADDRESSES_INTERVAL", "15")
+	//配置文件中没有的键
+	setEnv(t, "CHIA_MONITOR_MONITORS_WALLET_BALANCE_ENABLED", "false")
+	setEnv(t, "CHIA_MONITOR_MONITORS_POOL_EARNING_CRON", "0 0 12 * * *")
+	//与monitors前缀相近的配置段不受影响
+	setEnv(t, "CHIA_MONITOR_MONITOR_MACHINE_NAME", "farm-1")
+
+	if err := applyEnv(cfgData); err != nil {
+		t.Fatal(err)
+	}
+	if cfgData.Monitor.MachineName != "farm-1" {
+		t.Errorf("monitor.machineName = %q, want farm-1", cfgData.Monitor.MachineName)
+	}
+	watch := cfgData.Monitors["watchAddresses"]
+	if watch == nil || watch.Interval != 15 || watch.Enabled == nil || !*watch.Enabled {
+		t.Errorf("monitors.watchAddresses = %+v, want enabled with interval 15", watch)
+	}
+	walletBalance := cfgData.Monitors["walletBalance"]
+	if walletBalance == nil || walletBalance.Enabled == nil || *walletBalance.Enabled {
+		t.Errorf("monitors.walletBalance = %+v, want disabled", walletBalance)
+	}
+	poolEarning := cfgData.Monitors["poolEarning"]
+	if poolEarning == nil || poolEarning.Cron != "0 0 12 * * *" {
+		t.Errorf("monitors.poolEarning = %+v, want cron 0 0 12 * * *", poolEarning)
+	}
+	//未被覆盖的键保持不变
+	if mempool, ok := cfgData.Monitors["mempool"]; !ok || mempool != nil {
+		t.Errorf("monitors.mempool = %+v, want nil", mempool)
+	}
+}
+
+func TestCamelName(t *testing.T) {
+	for in, want := range map[string]string{
+		"WALLET_BALANCE":      "walletBalance",
+		"MEMPOOL":             "mempool",
+		"WALLET_TRANSACTIONS": "walletTransactions",
+	} {
+		if got := camelName(in); got != want {
+			t.Errorf("camelName(%q) = %q, want %q", in, got, want)
+		}
+		if got := envName(want); got != in {
+			t.Errorf("envName(%q) = %q, want %q", want, got, in)
+		}
+	}
+}
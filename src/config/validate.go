@@ -0,0 +1,290 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/robfig/cron"
+
+	"chia_monitor/src/bech32"
+)
+
+// knownPools 有专门对接的矿池，其他矿池使用官方矿池协议
+var knownPools = []string{"XCHPool", "Dpool"}
+
+// MonitorNames 所有监控的名称，monitors下的键必须是其中之一，新增监控时需要同时添加
+var MonitorNames = []string{"blockchain", "connections", "mempool", "watchAddresses", "walletReport", "walletSync",
+	"walletBalance", "walletTransactions", "farmer", "poolReport", "poolEarning"}
+
+// launcherIdLength launcher id的长度，32字节的十六进制
+const launcherIdLength = 64
+
+// ValidationError 配置校验未通过的问题列表
+type ValidationError struct {
+	Problems []string
+}
+
+// Error 每行一个问题
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// validator 收集校验问题
+type validator struct {
+	problems []string
+	warnings []string
+}
+
+// fail 记录问题
+func (v *validator) fail(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// warn 记录不影响运行的提示
+func (v *validator) warn(format string, args ...interface{}) {
+	v.warnings = append(v.warnings, fmt.Sprintf(format, args...))
+}
+
+// Validate 校验配置是否完整、有效，不访问文件及网络，用于启动及重新加载配置
+func (c *Config) Validate() error {
+	v := &validator{}
+	c.validate(v)
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// Check 完整检查配置，除Validate的检查外，还检查证书是否可读、收割机地址能否解析，返回问题及提示
+func (c *Config) Check() (problems []string, warnings []string) {
+	v := &validator{}
+	c.validate(v)
+	c.checkEnvironment(v)
+	return v.problems, v.warnings
+}
+
+// validate 检查必填项、地址、cron表达式及引用关系
+func (c *Config) validate(v *validator) {
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			v.fail("listen [%s] must be host:port: %s", c.Listen, err)
+		}
+	}
+	if c.LogConfig == nil {
+		v.fail("logConfig is required")
+	} else if c.LogConfig.LogDir == "" || c.LogConfig.AppName == "" {
+		v.fail("logConfig.logDir and logConfig.appName are required")
+	}
+
+	if c.Coin == nil {
+		v.fail("coin is required")
+	} else {
+		checkUrl(v, "coin.blockChainRpcUrl", c.Coin.BlockChainRpcUrl, "https")
+		checkUrl(v, "coin.walletRpcUrl", c.Coin.WalletRpcUrl, "https")
+		checkUrl(v, "coin.farmerRpcUrl", c.Coin.FarmerRpcUrl, "https")
+	}
+	if c.FullNodeCertPath == nil || c.FullNodeCertPath.CertPath == "" || c.FullNodeCertPath.KeyPath == "" {
+		v.fail("fullNodeCertPath.certPath and fullNodeCertPath.keyPath are required")
+	}
+	if c.WalletCertPath == nil || c.WalletCertPath.CertPath == "" || c.WalletCertPath.KeyPath == "" {
+		v.fail("walletCertPath.certPath and walletCertPath.keyPath are required")
+	}
+
+	if c.Monitor.MachineName == "" {
+		v.fail("monitor.machineName is required")
+	}
+	if c.Monitor.BockChainInterval <= 0 {
+		v.fail("monitor.blockChainInterval must be greater than 0")
+	}
+	if c.Monitor.FarmerInterval <= 0 {
+		v.fail("monitor.farmerInterval must be greater than 0")
+	}
+	checkCron(v, "monitor.dailyCron", c.Monitor.DailyCron)
+	if c.Monitor.IsSupportPool {
+		launcherId := strings.TrimPrefix(c.Monitor.LauncherId, "0x")
+		if _, err := hex.DecodeString(launcherId); err != nil || len(launcherId) != launcherIdLength {
+			v.fail("monitor.launcherId must be %d hex characters, got [%s]", launcherIdLength, c.Monitor.LauncherId)
+		}
+		if c.Monitor.PoolName == "" {
+			v.fail("monitor.poolName is required when isSupportPool is true")
+		} else if !isKnownPool(c.Monitor.PoolName) {
+			v.warn("monitor.poolName [%s] is not one of %s, earnings will be read by the official pool protocol",
+				c.Monitor.PoolName, strings.Join(knownPools, ", "))
+		}
+	}
+
+	for name, schedule := range c.Monitors {
+		//拼写错误的监控名称会被忽略，监控仍按默认配置运行
+		if !IsMonitorName(name) {
+			v.fail("monitors.%s is not a known monitor, must be one of %s", name, strings.Join(MonitorNames, ", "))
+		}
+		if schedule == nil {
+			continue
+		}
+		if schedule.Interval < 0 {
+			v.fail("monitors.%s.interval must not be negative", name)
+		}
+		if schedule.Cron != "" {
+			checkCron(v, "monitors."+name+".cron", schedule.Cron)
+		}
+	}
+
+	if c.PriceConfig.Provider == "http" {
+		checkUrl(v, "priceConfig.url", strings.Replace(c.PriceConfig.Url, "{currency}", "cny", -1), "http", "https")
+	} else if c.PriceConfig.Provider != "" && c.PriceConfig.Provider != "static" {
+		v.fail("priceConfig.provider must be http, static or empty, got [%s]", c.PriceConfig.Provider)
+	}
+	if c.ForkDetection.ReferenceRpcUrl != "" {
+		checkUrl(v, "forkDetection.referenceRpcUrl", c.ForkDetection.ReferenceRpcUrl, "https")
+	}
+
+	//观察地址名称用于指标，不能为空或重复，地址必须是当前网络的有效地址
+	watchNames := make(map[string]bool)
+	for i, watchAddress := range c.AddressWatch.Addresses {
+		field := fmt.Sprintf("addressWatch.addresses[%d]", i)
+		if watchAddress.Name == "" {
+			v.fail("%s.name is required", field)
+		} else if watchNames[watchAddress.Name] {
+			v.fail("%s.name [%s] is duplicated", field, watchAddress.Name)
+		}
+		watchNames[watchAddress.Name] = true
+		if _, err := bech32.DecodePuzzleHash(watchAddress.Address, c.AddressWatch.Prefix); err != nil {
+			v.fail("%s.address is invalid: %s", field, err)
+		}
+	}
+
+	checkUrl(v, "wechat.postUrl", c.Wechat.PostUrl, "http", "https")
+	if c.Wechat.Account == "" {
+		v.fail("wechat.account is required, set it in config file or %s_WECHAT_ACCOUNT", envPrefix)
+	}
+
+	checkUrl(v, "remediation.daemonUrl", c.Remediation.DaemonUrl, "wss")
+	checkUrl(v, "remediation.harvesterRpcUrl", c.Remediation.HarvesterRpcUrl, "https")
+	//restartChia为内置操作
+	actions := map[string]bool{"restartChia": true}
+	for i, action := range c.Remediation.Actions {
+		if action.Name == "" {
+			v.fail("remediation.actions[%d].name is required", i)
+			continue
+		}
+		if actions[action.Name] {
+			v.fail("remediation.actions[%d]: duplicate action name %s", i, action.Name)
+		}
+		actions[action.Name] = true
+	}
+	for condition, names := range c.Remediation.Escalations {
+		for _, name := range names {
+			if !actions[name] {
+				v.fail("remediation.escalations.%s: unknown action %s", condition, name)
+			}
+		}
+	}
+}
+
+// checkEnvironment 检查证书是否可读，收割机地址能否解析
+func (c *Config) checkEnvironment(v *validator) {
+	if c.FullNodeCertPath != nil {
+		checkReadable(v, "fullNodeCertPath.certPath", c.FullNodeCertPath.CertPath)
+		checkReadable(v, "fullNodeCertPath.keyPath", c.FullNodeCertPath.KeyPath)
+	}
+	if c.WalletCertPath != nil {
+		checkReadable(v, "walletCertPath.certPath", c.WalletCertPath.CertPath)
+		checkReadable(v, "walletCertPath.keyPath", c.WalletCertPath.KeyPath)
+	}
+	if c.ForkDetection.ReferenceRpcUrl != "" {
+		checkReadable(v, "forkDetection.referenceCertPath", c.ForkDetection.ReferenceCertPath)
+		checkReadable(v, "forkDetection.referenceKeyPath", c.ForkDetection.ReferenceKeyPath)
+	}
+	//只在配置了相应修复操作时才需要守护进程及收割机证书
+	var needDaemon, needHarvester bool
+	for _, action := range c.Remediation.Actions {
+		needDaemon = needDaemon || action.Type == "restartService"
+		needHarvester = needHarvester || action.Type == "refreshPlots"
+	}
+	if needDaemon {
+		checkReadable(v, "remediation.daemonCertPath", c.Remediation.DaemonCertPath)
+		checkReadable(v, "remediation.daemonKeyPath", c.Remediation.DaemonKeyPath)
+	}
+	if needHarvester {
+		checkReadable(v, "remediation.harvesterCertPath", c.Remediation.HarvesterCertPath)
+		checkReadable(v, "remediation.harvesterKeyPath", c.Remediation.HarvesterKeyPath)
+	}
+
+	for _, harvester := range c.Monitor.HarvesterList {
+		if net.ParseIP(harvester) != nil {
+			continue
+		}
+		if _, err := net.ResolveIPAddr("ip", harvester); err != nil {
+			v.fail("monitor.harvesterList: cannot resolve %s: %s", harvester, err)
+		}
+	}
+}
+
+// checkUrl 检查地址格式及协议
+func checkUrl(v *validator, field string, value string, schemes ...string) {
+	if value == "" {
+		v.fail("%s is required", field)
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		v.fail("%s [%s] is not a valid url: %s", field, value, err)
+		return
+	}
+	if u.Host == "" {
+		v.fail("%s [%s] has no host", field, value)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return
+		}
+	}
+	v.fail("%s [%s] must use %s", field, value, strings.Join(schemes, " or "))
+}
+
+// checkCron 检查cron表达式，格式与调度器一致：秒 分 时 日 月 周
+func checkCron(v *validator, field string, spec string) {
+	if spec == "" {
+		v.fail("%s is required", field)
+		return
+	}
+	if _, err := cron.Parse(spec); err != nil {
+		v.fail("%s [%s] is invalid: %s", field, spec, err)
+	}
+}
+
+// checkReadable 检查文件是否存在且可读
+func checkReadable(v *validator, field string, path string) {
+	if path == "" {
+		v.fail("%s is required", field)
+		return
+	}
+	if _, err := ioutil.ReadFile(path); err != nil {
+		v.fail("%s: %s", field, err)
+	}
+}
+
+// IsMonitorName 是否为已有的监控名称
+func IsMonitorName(name string) bool {
+	for _, monitorName := range MonitorNames {
+		if monitorName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownPool 是否为有专门对接的矿池
+func isKnownPool(name string) bool {
+	for _, pool := range knownPools {
+		if pool == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package ledger
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"chia_monitor/src/mojo"
+	"chia_monitor/src/utils"
+)
+
+const dateLayout = "2006-01-02"
+
+// Period 统计周期
+type Period int
+
+const (
+	Day Period = iota
+	Week
+	Month
+)
+
+// Record 收益记录
+type Record struct {
+	Pool       string      `json:"pool"`        //矿池名称
+	Date       string      `json:"date"`        //收益日期：2006-01-02
+	Amount     mojo.Amount `json:"amount"`      //收益数量，单位：mojo
+	CoinId     string      `json:"coin_id"`     //支付的coin id，按天统计收益的矿池为空
+	BlockIndex int64       `json:"block_index"` //支付所在区块高度
+	UpdateTime time.Time   `json:"update_time"` //最后更新时间
+
+	Fiat map[string]float64 `json:"fiat,omitempty"` //记录收益时的法币价值
+}
+
+// key 记录唯一标识，有coin id的按coin id去重，否则按日期去重
+func (r Record) key() string {
+	if r.CoinId != "" {
+		return r.Pool + "|coin|" + r.CoinId
+	}
+	return r.Pool + "|date|" + r.Date
+}
+
+// same 除更新时间和法币价值以外的字段是否相同
+func (r Record) same(other Record) bool {
+	return r.Pool == other.Pool &&
+		r.Date == other.Date &&
+		r.Amount == other.Amount &&
+		r.CoinId == other.CoinId &&
+		r.BlockIndex == other.BlockIndex
+}
+
+// PeriodTotal 周期收益合计
+type PeriodTotal struct {
+	Period string      `json:"period"` //周期起始日期，如2006-01-02、2006-W01、2006-01
+	Amount mojo.Amount `json:"amount"` //合计收益，单位：mojo
+	Count  int         `json:"count"`  //记录条数
+}
+
+// Ledger 收益账本，保存在本地json文件中
+type Ledger struct {
+	path    string
+	mu      sync.RWMutex
+	records map[string]Record
+}
+
+// Open 打开收益账本，文件不存在时创建空账本
+func Open(path string) (*Ledger, error) {
+	l := &Ledger{
+		path:    path,
+		records: make(map[string]Record),
+	}
+	var records []Record
+	err := utils.ReadJSONFile(path, &records)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "read ledger %s", path)
+	}
+	for _, record := range records {
+		l.records[record.key()] = record
+	}
+	return l, nil
+}
+
+// Upsert 插入或更新收益记录，重复写入同一条记录不会产生重复数据
+func (l *Ledger) Upsert(records ...Record) (inserted, updated int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, record := range records {
+		if _, err = time.Parse(dateLayout, record.Date); err != nil {
+			return inserted, updated, errors.Wrapf(err, "invalid date of record %+v", record)
+		}
+		key := record.key()
+		old, exists := l.records[key]
+		if exists && old.same(record) {
+			continue
+		}
+		if record.Fiat == nil {
+			record.Fiat = old.Fiat
+		}
+		record.UpdateTime = time.Now()
+		l.records[key] = record
+		if exists {
+			updated++
+		} else {
+			inserted++
+		}
+	}
+	if inserted == 0 && updated == 0 {
+		return
+	}
+
+	return inserted, updated, l.save()
+}
+
+// save 按矿池、日期排序后写入文件，调用方需持有锁
+func (l *Ledger) save() error {
+	records := make([]Record, 0, len(l.records))
+	for _, record := range l.records {
+		records = append(records, record)
+	}
+	sortRecords(records)
+	return utils.WriteJSONFile(l.path, records)
+}
+
+// Records 查询[from, to]日期内的收益记录，pool为空时查询所有矿池
+func (l *Ledger) Records(pool string, from, to time.Time) []Record {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	fromDate, toDate := from.Format(dateLayout), to.Format(dateLayout)
+	var records []Record
+	for _, record := range l.records {
+		if pool != "" && record.Pool != pool {
+			continue
+		}
+		if record.Date < fromDate || record.Date > toDate {
+			continue
+		}
+		records = append(records, record)
+	}
+	sortRecords(records)
+	return records
+}
+
+// Total 查询[from, to]日期内的收益合计
+func (l *Ledger) Total(pool string, from, to time.Time) (total mojo.Amount) {
+	for _, record := range l.Records(pool, from, to) {
+		total += record.Amount
+	}
+	return total
+}
+
+// FiatTotals 查询[from, to]日期内的收益按记录时价格计算的法币价值合计
+func (l *Ledger) FiatTotals(pool string, from, to time.Time) map[string]float64 {
+	totals := make(map[string]float64)
+	for _, record := range l.Records(pool, from, to) {
+		for currency, value := range record.Fiat {
+			totals[currency] += value
+		}
+	}
+	return totals
+}
+
+// Totals 按天、周、月统计[from, to]日期内的收益
+func (l *Ledger) Totals(pool string, from, to time.Time, period Period) []PeriodTotal {
+	var totals []PeriodTotal
+	index := make(map[string]int)
+	for _, record := range l.Records(pool, from, to) {
+		date, _ := time.Parse(dateLayout, record.Date)
+		name := periodName(date, period)
+		i, ok := index[name]
+		if !ok {
+			i = len(totals)
+			index[name] = i
+			totals = append(totals, PeriodTotal{Period: name})
+		}
+		totals[i].Amount += record.Amount
+		totals[i].Count++
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Period < totals[j].Period })
+	return totals
+}
+
+// MissingDays 查询[from, to]日期内没有收益记录的日期
+func (l *Ledger) MissingDays(pool string, from, to time.Time) []string {
+	days := make(map[string]bool)
+	for _, record := range l.Records(pool, from, to) {
+		days[record.Date] = true
+	}
+	var missingDays []string
+	toDate := to.Format(dateLayout)
+	for date := from; date.Format(dateLayout) <= toDate; date = date.AddDate(0, 0, 1) {
+		if !days[date.Format(dateLayout)] {
+			missingDays = append(missingDays, date.Format(dateLayout))
+		}
+	}
+	return missingDays
+}
+
+// ComparePools 对比[from, to]日期内各个矿池的收益
+func (l *Ledger) ComparePools(from, to time.Time) map[string]mojo.Amount {
+	totals := make(map[string]mojo.Amount)
+	for _, record := range l.Records("", from, to) {
+		totals[record.Pool] += record.Amount
+	}
+	return totals
+}
+
+// periodName 日期所在周期的名称
+func periodName(date time.Time, period Period) string {
+	switch period {
+	case Week:
+		year, week := date.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case Month:
+		return date.Format("2006-01")
+	default:
+		return date.Format(dateLayout)
+	}
+}
+
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Pool != records[j].Pool {
+			return records[i].Pool < records[j].Pool
+		}
+		if records[i].Date != records[j].Date {
+			return records[i].Date < records[j].Date
+		}
+		return records[i].CoinId < records[j].CoinId
+	})
+}
@@ -0,0 +1,108 @@
+package ledger
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTemp(t *testing.T) (*Ledger, string) {
+	path := filepath.Join(t.TempDir(), "earnings.json")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, path
+}
+
+func TestUpsertByCoinId(t *testing.T) {
+	l, path := openTemp(t)
+	records := []Record{
+		{Pool: "XCHPool", Date: "2026-10-01", Amount: 100, CoinId: "0x01", BlockIndex: 1},
+		{Pool: "XCHPool", Date: "2026-10-01", Amount: 200, CoinId: "0x02", BlockIndex: 2},
+	}
+	inserted, updated, err := l.Upsert(records...)
+	if err != nil || inserted != 2 || updated != 0 {
+		t.Fatalf("Upsert = %d, %d, %v, want 2 inserted", inserted, updated, err)
+	}
+	//重复写入相同记录不产生变化
+	inserted, updated, err = l.Upsert(records...)
+	if err != nil || inserted != 0 || updated != 0 {
+		t.Errorf("Upsert same records = %d, %d, %v, want no change", inserted, updated, err)
+	}
+	//同一coin的数量变化时更新
+	inserted, updated, err = l.Upsert(Record{Pool: "XCHPool", Date: "2026-10-01", Amount: 150, CoinId: "0x01", BlockIndex: 1})
+	if err != nil || inserted != 0 || updated != 1 {
+		t.Errorf("Upsert changed record = %d, %d, %v, want 1 updated", inserted, updated, err)
+	}
+
+	day := time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local)
+	if total := l.Total("XCHPool", day, day); total != 350 {
+		t.Errorf("Total = %d, want 350", total)
+	}
+	//重新打开后记录保持不变
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := reopened.Records("", day, day), l.Records("", day, day)
+	if len(got) != len(want) {
+		t.Fatalf("reopened records = %+v, want %+v", got, want)
+	}
+	for i := range got {
+		if !got[i].same(want[i]) {
+			t.Errorf("reopened record = %+v, want %+v", got[i], want[i])
+		}
+	}
+}
+
+func TestUpsertByDate(t *testing.T) {
+	l, _ := openTemp(t)
+	fiat := map[string]float64{"CNY": 1.5}
+	inserted, _, err := l.Upsert(Record{Pool: "Dpool", Date: "2026-10-01", Amount: 100, Fiat: fiat})
+	if err != nil || inserted != 1 {
+		t.Fatalf("Upsert = %d, %v, want 1 inserted", inserted, err)
+	}
+	//按pool|date去重，当天收益增加时更新，没有法币价值时保留之前的
+	inserted, updated, err := l.Upsert(Record{Pool: "Dpool", Date: "2026-10-01", Amount: 120})
+	if err != nil || inserted != 0 || updated != 1 {
+		t.Errorf("Upsert same date = %d, %d, %v, want 1 updated", inserted, updated, err)
+	}
+	//不同矿池同一天是不同的记录
+	inserted, _, err = l.Upsert(Record{Pool: "XCHPool", Date: "2026-10-01", Amount: 100})
+	if err != nil || inserted != 1 {
+		t.Errorf("Upsert other pool = %d, %v, want 1 inserted", inserted, err)
+	}
+
+	day := time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local)
+	records := l.Records("Dpool", day, day)
+	if len(records) != 1 || records[0].Amount != 120 || !reflect.DeepEqual(records[0].Fiat, fiat) {
+		t.Errorf("Records = %+v, want one record of 120 with fiat %v", records, fiat)
+	}
+
+	if _, _, err := l.Upsert(Record{Pool: "Dpool", Date: "2026/10/01", Amount: 1}); err == nil {
+		t.Error("Upsert with invalid date err = nil, want error")
+	}
+}
+
+func TestMissingDays(t *testing.T) {
+	l, _ := openTemp(t)
+	_, _, err := l.Upsert(
+		Record{Pool: "Dpool", Date: "2026-09-29", Amount: 1},
+		Record{Pool: "Dpool", Date: "2026-10-01", Amount: 1},
+		Record{Pool: "XCHPool", Date: "2026-09-30", Amount: 1, CoinId: "0x01"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, 9, 28, 12, 0, 0, 0, time.Local)
+	to := time.Date(2026, 10, 2, 8, 0, 0, 0, time.Local)
+	want := []string{"2026-09-28", "2026-09-30", "2026-10-02"}
+	if got := l.MissingDays("Dpool", from, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("MissingDays(Dpool) = %v, want %v", got, want)
+	}
+	if got := l.MissingDays("", from, to); !reflect.DeepEqual(got, []string{"2026-09-28", "2026-10-02"}) {
+		t.Errorf("MissingDays(all) = %v, want [2026-09-28 2026-10-02]", got)
+	}
+}
@@ -19,6 +19,17 @@ import (
 	"chia_monitor/src/config"
 )
 
+//日志文件，退出时关闭
+var fileWriter io.Closer
+
+//是否同时输出到屏幕
+var console = true
+
+//DisableConsole 日志只输出到文件，用于在屏幕输出结果的子命令，需要在InitLog之前调用
+func DisableConsole() {
+	console = false
+}
+
 //初始化日志设置
 func InitLog(LogDir string, appName string, isProduction bool) {
 	//获取配置文件
@@ -42,11 +53,17 @@ func InitLog(LogDir string, appName string, isProduction bool) {
 	)
 	if err != nil {
 		log.Errorf("Config local file system middleware error. %v", errors.WithStack(err))
+	} else {
+		fileWriter = file_writer
 	}
 
 	log.SetReportCaller(true) //打印文件和行号
 
-	log.SetOutput(io.MultiWriter(file_writer, os.Stdout)) //同时输出到文件和屏幕
+	if console {
+		log.SetOutput(io.MultiWriter(file_writer, os.Stdout)) //同时输出到文件和屏幕
+	} else {
+		log.SetOutput(file_writer)
+	}
 
 	//设置日志格式和级别
 	if isProduction {
@@ -87,4 +104,15 @@ func getGID() uint64 {
 	b = b[:bytes.IndexByte(b, ' ')]
 	n, _ := strconv.ParseUint(string(b), 10, 64)
 	return n
-}
\ No newline at end of file
+}
+
+//Close 关闭日志文件，之后的日志只输出到屏幕
+func Close() {
+	if fileWriter == nil {
+		return
+	}
+	log.Info("=====================Log closed=====================")
+	log.SetOutput(os.Stdout)
+	_ = fileWriter.Close()
+	fileWriter = nil
+}
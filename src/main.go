@@ -1,34 +1,207 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"chia_monitor/src/api"
 	"chia_monitor/src/chia"
 	"chia_monitor/src/config"
 	"chia_monitor/src/logger"
+	"chia_monitor/src/monitor"
+	"chia_monitor/src/wechat"
 )
 
-var (
-	nodeEventTest   bool
-	walletEventTest bool
-	farmerEventTest bool
-)
+// shutdownTimeout 退出时等待监控结束及通知发送的最长时间
+const shutdownTimeout = 30 * time.Second
+
+var dryRun bool
 
 func main() {
-	//获取配置文件
+	//获取命令行参数
+	flag.Parse()
+	//未指定子命令时运行监控
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"run"}
+	}
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+		flag.Usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(args[1:]))
+}
+
+// setup 加载配置文件并初始化日志，配置无效时直接退出，console为false时日志不输出到屏幕
+func setup(console bool) *config.Config {
+	//加载配置文件
+	if err := config.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	//命令行开启演练模式，重新加载配置后依然有效
+	if dryRun {
+		config.AddOverride(func(c *config.Config) {
+			c.DryRun.Enabled = true
+		})
+	}
 	cfg := config.GetConfig()
+	//初始化日志模块
+	if !console {
+		logger.DisableConsole()
+	}
+	logger.InitLog(cfg.LogConfig.LogDir, cfg.LogConfig.AppName, cfg.LogConfig.IsProduction)
+	if cfg.DryRun.Enabled {
+		log.Warn("Dry-run mode is enabled, remediation actions will only be logged")
+	}
+	return cfg
+}
+
+// runDaemon 按调度持续运行所有监控，收到SIGTERM或SIGINT时退出
+func runDaemon(args []string) int {
+	cfg := setup(true)
 	log.Infof("Start %s monitor...", cfg.Coin.Name)
 
-	//获取命令行参数
-	flag.Parse()
-	//测试节点事件
-	if nodeEventTest {
-		chia.TestNodeEvent()
+	//收到SIGTERM或SIGINT时取消ctx
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	//写入pid文件
+	if err := writePidFile(cfg.PidFile); err != nil {
+		log.Errorf("Write pid file [%s] failed: %s", cfg.PidFile, err)
+	}
+	//重新开始判断收割机掉线
+	chia.ClearHarvesterOfflineFlag()
+
+	//注册所有监控，按各自的间隔或cron调度
+	registry := newRegistry(cfg)
+	scheduler := monitor.NewScheduler(registry)
+	scheduler.Start(ctx)
+	//收到SIGHUP或配置文件修改时重新加载配置
+	go watchConfig(ctx, scheduler)
+
+	//配置了监听地址时启动状态接口
+	var server *api.Server
+	if cfg.Listen != "" {
+		server = api.NewServer(cfg.Listen, registry, func(cfg *config.Config) (chia.Farmer, chia.Wallet) {
+			_, wallet, farmer := newClients(cfg)
+			return farmer, wallet
+		})
+		if err := server.Start(); err != nil {
+			log.Errorf("Start status api on %s failed: %s", cfg.Listen, err)
+			server = nil
+		}
+	}
+
+	<-ctx.Done()
+	//恢复默认的信号处理，再次收到信号时直接退出
+	stop()
+	log.Info("Receive stop signal, shutting down...")
+	shutdown(server, scheduler, cfg.PidFile)
+	return 0
+}
+
+// checkConfig 检查配置文件，输出所有问题，有问题时返回1
+func checkConfig(args []string) int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Load config %s failed: %s\n", config.Path(), err)
+		return 1
+	}
+	problems, warnings := cfg.Check()
+	for _, warning := range warnings {
+		fmt.Printf("WARN  %s\n", warning)
+	}
+	for _, problem := range problems {
+		fmt.Printf("ERROR %s\n", problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("Config %s has %d problem(s)\n", config.Path(), len(problems))
+		return 1
+	}
+	fmt.Printf("Config %s is OK\n", config.Path())
+	return 0
+}
+
+// shutdown 停止状态接口及所有监控，发送完未发送的通知，删除pid文件并关闭日志
+func shutdown(server *api.Server, scheduler *monitor.Scheduler, pidFile string) {
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("Shutdown status api err: %s", err)
+		}
+		cancel()
+	}
+	if !scheduler.Stop(shutdownTimeout) {
+		log.Warn("Some monitors are still running, exit anyway")
+	}
+	if !wechat.Flush(shutdownTimeout) {
+		log.Warn("Some wechat notices are not sent")
+	}
+	if err := os.Remove(pidFile); err != nil {
+		log.Errorf("Remove pid file [%s] failed: %s", pidFile, err)
+	}
+	log.Info("Chia monitor exit")
+	logger.Close()
+}
+
+// watchConfig 收到SIGHUP或定时检查到配置文件修改时重新加载配置
+func watchConfig(ctx context.Context, scheduler *monitor.Scheduler) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		interval := time.Duration(config.GetConfig().ReloadInterval) * time.Second
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info("Receive SIGHUP, reload config")
+			reloadConfig(scheduler)
+		case <-time.After(interval):
+			if config.Changed() {
+				log.Info("Config file changed, reload config")
+				reloadConfig(scheduler)
+			}
+		}
+	}
+}
+
+// reloadConfig 重新加载配置并重新调度变化的监控，配置无效时继续使用当前配置
+func reloadConfig(scheduler *monitor.Scheduler) {
+	old, cfg, err := config.Reload()
+	if err != nil {
+		log.Errorf("Reload config failed, keep current config: %s", err)
 		return
 	}
+	//rpc地址、证书及日志配置在启动时使用，修改后需要重启
+	if *old.Coin != *cfg.Coin || *old.FullNodeCertPath != *cfg.FullNodeCertPath ||
+		*old.WalletCertPath != *cfg.WalletCertPath || *old.LogConfig != *cfg.LogConfig ||
+		old.PidFile != cfg.PidFile || old.Listen != cfg.Listen || old.Monitor.IsSupportPool != cfg.Monitor.IsSupportPool {
+		log.Warn("Changes of coin, cert paths, logConfig, pidFile, listen or isSupportPool take effect after restart")
+	}
+	scheduler.Reschedule()
+	log.Info("Config reloaded")
+}
 
+// writePidFile 写入当前进程的pid
+func writePidFile(pidFile string) error {
+	return ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// newClients 创建chia的rpc对象
+func newClients(cfg *config.Config) (chia.BlockChain, chia.Wallet, chia.Farmer) {
 	//区块链对象
 	blockChain := chia.BlockChain{
 		BaseUrl:  cfg.Coin.BlockChainRpcUrl,
@@ -36,19 +209,13 @@ func main() {
 		KeyPath:  cfg.FullNodeCertPath.KeyPath,
 		WalletId: 1,
 	}
-	//监控区块链状态
-	go chia.MonitorBlockState(blockChain)
-
-	//钱包对象
+	//钱包对象，WalletId为标准钱包，用于监控交易，余额监控会获取所有钱包
 	wallet := chia.Wallet{
 		BaseUrl:  cfg.Coin.WalletRpcUrl,
 		CertPath: cfg.WalletCertPath.CertPath,
 		KeyPath:  cfg.WalletCertPath.KeyPath,
 		WalletId: 1,
 	}
-	//监控钱包状态
-	go chia.MonitorWallet(wallet)
-
 	//农民对象
 	farmer := chia.Farmer{
 		BaseUrl:               cfg.Coin.FarmerRpcUrl,
@@ -56,27 +223,66 @@ func main() {
 		KeyPath:               cfg.WalletCertPath.KeyPath,
 		IsSearchForPrivateKey: false,
 	}
+
+	return blockChain, wallet, farmer
+}
+
+// newRegistry 创建chia对象并注册所有监控
+func newRegistry(cfg *config.Config) *monitor.Registry {
+	blockChain, wallet, farmer := newClients(cfg)
+	registry := monitor.NewRegistry()
+
+	//监控区块链状态
+	blockState := chia.NewBlockStateMonitor(blockChain)
+	registry.Register(blockState, blockState.Schedule)
+	//监控全节点连接
+	connection := chia.NewConnectionMonitor(blockChain)
+	registry.Register(connection, connection.Schedule)
+	//监控内存池
+	mempool := chia.NewMempoolMonitor(blockChain)
+	registry.Register(mempool, mempool.Schedule)
+	//监控观察地址
+	watchAddress := chia.NewWatchAddressMonitor(blockChain)
+	registry.Register(watchAddress, watchAddress.Schedule)
+
+	//每日发送钱包余额、内存池状态及推荐手续费
+	walletReport := chia.NewWalletReportMonitor(wallet, blockChain, mempool)
+	registry.Register(walletReport, walletReport.Schedule)
+	//监控钱包同步状态
+	walletSync := chia.NewWalletSyncMonitor(wallet, blockChain)
+	registry.Register(walletSync, walletSync.Schedule)
+	//监控各个钱包余额
+	walletBalance := chia.NewWalletBalanceMonitor(wallet, blockChain)
+	registry.Register(walletBalance, walletBalance.Schedule)
+	//监控钱包交易
+	walletTransaction := chia.NewWalletTransactionMonitor(wallet)
+	registry.Register(walletTransaction, walletTransaction.Schedule)
+
 	//监控耕种状态
-	go chia.MonitorFarmer(farmer)
+	farmerMonitor := chia.NewFarmerMonitor(farmer)
+	registry.Register(farmerMonitor, farmerMonitor.Schedule)
 
 	//新协议，支持矿池
 	if cfg.Monitor.IsSupportPool {
 		//监控矿池状态
-		go chia.MonitorPool(farmer)
-
+		poolReport := chia.NewPoolReportMonitor(farmer)
+		registry.Register(poolReport, poolReport.Schedule)
 		//监控矿池收益
-		go chia.MonitorPoolEarning(cfg.PoolName)
+		poolEarning := chia.NewPoolEarningMonitor(cfg.PoolName, farmer)
+		registry.Register(poolEarning, poolEarning.Schedule)
+	}
+
+	//配置校验只接受config.MonitorNames中的名称，新增监控时遗漏会导致无法配置
+	for _, m := range registry.Monitors() {
+		if !config.IsMonitorName(m.Name()) {
+			log.Fatalf("Monitor %s is not in config.MonitorNames", m.Name())
+		}
 	}
 
-	select {}
+	return registry
 }
 
 func init() {
-	flag.BoolVar(&nodeEventTest, "n", false, "测试node事件")
-	flag.BoolVar(&walletEventTest, "w", false, "测试wallet事件")
-	flag.BoolVar(&farmerEventTest, "f", false, "测试farmer事件")
-	//获取配置文件
-	cfg := config.GetConfig()
-	//初始化日志模块
-	logger.InitLog(cfg.LogConfig.LogDir, cfg.LogConfig.AppName, cfg.LogConfig.IsProduction)
+	flag.BoolVar(&dryRun, "d", false, "演练模式，修复操作只记录日志不执行")
+	flag.Usage = usage
 }
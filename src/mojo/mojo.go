@@ -0,0 +1,145 @@
+package mojo
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PerXCH 1 XCH = 10^12 mojo
+const PerXCH = 1000000000000
+
+// PerCAT 1 CAT = 10^3 mojo
+const PerCAT = 1000
+
+// Decimals XCH的小数位数
+const Decimals = 12
+
+var (
+	perXCH    = big.NewInt(PerXCH)
+	perCAT    = big.NewInt(PerCAT)
+	maxAmount = new(big.Int).SetUint64(^uint64(0))
+)
+
+// Amount XCH数量，单位：mojo，所有计算都使用整数，避免浮点数的精度误差
+type Amount uint64
+
+// Parse 解析mojo整数字符串
+func Parse(s string) (Amount, error) {
+	value, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+	if !ok {
+		return 0, errors.Errorf("invalid mojo amount %q", s)
+	}
+	return fromBigInt(value, s)
+}
+
+// ParseXCH 解析XCH数量字符串，支持小数和科学计数法，超出12位小数的部分四舍五入到mojo
+func ParseXCH(s string) (Amount, error) {
+	return parseDecimal(s, perXCH)
+}
+
+// ParseCAT 解析CAT数量字符串，超出3位小数的部分四舍五入到mojo
+func ParseCAT(s string) (Amount, error) {
+	return parseDecimal(s, perCAT)
+}
+
+// parseDecimal 解析小数字符串，乘以unit后四舍五入为整数
+func parseDecimal(s string, unit *big.Int) (Amount, error) {
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, errors.Errorf("invalid amount %q", s)
+	}
+	value.Mul(value, new(big.Rat).SetInt(unit))
+	//四舍五入：floor(num * 2 + denom) / (denom * 2)
+	num := new(big.Int).Mul(value.Num(), big.NewInt(2))
+	num.Add(num, value.Denom())
+	denom := new(big.Int).Mul(value.Denom(), big.NewInt(2))
+	return fromBigInt(num.Div(num, denom), s)
+}
+
+// fromBigInt 检查范围后转换为Amount
+func fromBigInt(value *big.Int, s string) (Amount, error) {
+	if value.Sign() < 0 || value.Cmp(maxAmount) > 0 {
+		return 0, errors.Errorf("amount %q out of range", s)
+	}
+	return Amount(value.Uint64()), nil
+}
+
+// Format 格式化为XCH，保留decimals位小数，四舍五入
+func (a Amount) Format(decimals int) string {
+	return format(uint64(a), Decimals, decimals)
+}
+
+// FormatCAT 格式化为CAT数量，保留decimals位小数，四舍五入
+func (a Amount) FormatCAT(decimals int) string {
+	return format(uint64(a), 3, decimals)
+}
+
+// String 格式化为完整精度的XCH
+func (a Amount) String() string {
+	return a.Format(Decimals)
+}
+
+// XCH 转换为浮点数的XCH，仅用于汇率换算等不需要精确的场景
+func (a Amount) XCH() float64 {
+	return float64(a) / PerXCH
+}
+
+// Mojo 转换为mojo整数
+func (a Amount) Mojo() uint64 {
+	return uint64(a)
+}
+
+// Diff 计算a-b，返回差值的绝对值以及是否为负
+func (a Amount) Diff(b Amount) (diff Amount, negative bool) {
+	if a >= b {
+		return a - b, false
+	}
+	return b - a, true
+}
+
+// UnmarshalJSON 支持json数字和数字字符串，单位：mojo
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), "\"")
+	if s == "" || s == "null" {
+		*a = 0
+		return nil
+	}
+	amount, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// MarshalJSON 序列化为json数字，单位：mojo
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatUint(uint64(a), 10)), nil
+}
+
+// format 将整数value（exponent位小数）格式化为decimals位小数
+func format(value uint64, exponent, decimals int) string {
+	if decimals < 0 {
+		decimals = 0
+	}
+	n := new(big.Int).SetUint64(value)
+	if decimals < exponent {
+		//四舍五入到decimals位小数
+		unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent-decimals)), nil)
+		n.Add(n, new(big.Int).Div(unit, big.NewInt(2)))
+		n.Div(n, unit)
+	} else {
+		n.Mul(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals-exponent)), nil))
+	}
+	digits := n.String()
+	if decimals == 0 {
+		return digits
+	}
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	return digits[:len(digits)-decimals] + "." + digits[len(digits)-decimals:]
+}
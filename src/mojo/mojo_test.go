@@ -0,0 +1,107 @@
+package mojo
+
+import "testing"
+
+func TestParseXCH(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"1", PerXCH, false},
+		{" 1.5 ", 1500000000000, false},
+		{"0.000000000001", 1, false},
+		{"1.000000000001", 1000000000001, false},
+		{"0.123456789012", 123456789012, false},
+		//超出12位小数的部分四舍五入到mojo
+		{"0.0000000000014", 1, false},
+		{"0.0000000000015", 2, false},
+		{"0.0000000000004", 0, false},
+		{"1e-12", 1, false},
+		{"2.5e3", 2500 * PerXCH, false},
+		//uint64最大为18446744073709551615 mojo
+		{"18446744.073709551615", 18446744073709551615, false},
+		{"18446744.073709551616", 0, true},
+		{"100000000", 0, true},
+		{"-1", 0, true},
+		{"-0.000000000001", 0, true},
+		{"", 0, true},
+		{"abc", 0, true},
+		{"1.2.3", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseXCH(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseXCH(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseXCH(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"1750000000000", 1750000000000, false},
+		{"18446744073709551615", 18446744073709551615, false},
+		{"18446744073709551616", 0, true},
+		{"-1", 0, true},
+		{"1.5", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount   Amount
+		decimals int
+		want     string
+	}{
+		{0, 12, "0.000000000000"},
+		{1, 12, "0.000000000001"},
+		{PerXCH, 12, "1.000000000000"},
+		{1750000000000, 2, "1.75"},
+		{1750000000000, 0, "2"},
+		{1234567890123, 5, "1.23457"},
+		{1234564999999, 5, "1.23456"},
+		{999999999999, 3, "1.000"},
+		{1, 5, "0.00000"},
+		{5000000, 5, "0.00001"},
+		{PerXCH, 14, "1.00000000000000"},
+		{PerXCH, -1, "1"},
+		{18446744073709551615, 12, "18446744.073709551615"},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Format(tt.decimals); got != tt.want {
+			t.Errorf("Amount(%d).Format(%d) = %s, want %s", tt.amount, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestParseFormatRoundTrip(t *testing.T) {
+	for _, s := range []string{"0.000000000001", "1.000000000000", "123.456789012345", "18446744.073709551615"} {
+		amount, err := ParseXCH(s)
+		if err != nil {
+			t.Fatalf("ParseXCH(%q): %v", s, err)
+		}
+		if got := amount.String(); got != s {
+			t.Errorf("ParseXCH(%q).String() = %s", s, got)
+		}
+	}
+}
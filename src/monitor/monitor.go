@@ -0,0 +1,117 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Status 监控状态，取值与Nagios插件的返回码一致
+type Status int
+
+// 监控状态
+const (
+	StatusOK Status = iota
+	StatusWarning
+	StatusCritical
+	StatusUnknown
+)
+
+// String 状态名称
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "OK"
+	case StatusWarning:
+		return "WARNING"
+	case StatusCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// MarshalJSON 序列化为状态名称
+func (s Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Worse 返回两个状态中更严重的一个，Unknown只比OK严重
+func Worse(a, b Status) Status {
+	if severity(a) >= severity(b) {
+		return a
+	}
+	return b
+}
+
+// severity 状态的严重程度
+func severity(s Status) int {
+	switch s {
+	case StatusOK:
+		return 0
+	case StatusUnknown:
+		return 1
+	case StatusWarning:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Result 一次监控检查的结果
+type Result struct {
+	Monitor string             `json:"monitor"`           //监控名称
+	Status  Status             `json:"status"`            //状态
+	Summary string             `json:"summary"`           //一句话描述
+	Detail  string             `json:"detail,omitempty"`  //详细信息
+	Time    time.Time          `json:"time"`              //检查时间
+	Err     error              `json:"-"`                 //检查出错时的错误
+	Metrics map[string]float64 `json:"metrics,omitempty"` //指标，用于性能数据及状态接口
+}
+
+// NewResult 创建检查结果
+func NewResult(monitor string, status Status, summary string) Result {
+	return Result{
+		Monitor: monitor,
+		Status:  status,
+		Summary: summary,
+		Time:    time.Now(),
+		Metrics: make(map[string]float64),
+	}
+}
+
+// ErrorResult 检查出错时的结果
+func ErrorResult(monitor string, status Status, summary string, err error) Result {
+	result := NewResult(monitor, status, summary)
+	result.Err = err
+	if err != nil {
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+// Monitor 监控项
+type Monitor interface {
+	// Name 监控名称，与配置中monitors的键一致
+	Name() string
+	// Run 由调度器定时执行：检查状态，发送通知，执行自动修复
+	Run(ctx context.Context) Result
+	// Check 只检查当前状态，不发送通知也不执行自动修复
+	Check(ctx context.Context) Result
+}
+
+// Schedule 监控的调度方式，Cron不为空时按Cron执行，否则按Interval间隔执行
+type Schedule struct {
+	Interval time.Duration
+	Cron     string
+}
+
+// Every 每隔minutes分钟执行一次
+func Every(minutes int) Schedule {
+	return Schedule{Interval: time.Duration(minutes) * time.Minute}
+}
+
+// Cron 按cron表达式执行
+func Cron(spec string) Schedule {
+	return Schedule{Cron: spec}
+}
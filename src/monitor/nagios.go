@@ -0,0 +1,69 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Overall 所有结果中最严重的状态，没有结果时为Unknown
+func Overall(results []Result) Status {
+	if len(results) == 0 {
+		return StatusUnknown
+	}
+	status := StatusOK
+	for _, result := range results {
+		status = Worse(status, result.Status)
+	}
+	return status
+}
+
+// Perfdata Nagios插件格式的性能数据，标签为"监控名称.指标名称"，按标签排序
+func (r Result) Perfdata() []string {
+	keys := make([]string, 0, len(r.Metrics))
+	for key := range r.Metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	perfdata := make([]string, 0, len(keys))
+	for _, key := range keys {
+		label := r.Monitor + "." + key
+		//标签包含空格、等号或单引号时需要用单引号包裹，单引号写为两个单引号
+		if strings.ContainsAny(label, " ='") {
+			label = "'" + strings.Replace(label, "'", "''", -1) + "'"
+		}
+		perfdata = append(perfdata, label+"="+strconv.FormatFloat(r.Metrics[key], 'f', -1, 64))
+	}
+	return perfdata
+}
+
+// Nagios 按Nagios/Icinga插件格式输出：第一行为总体状态及性能数据，之后每行一个监控的结果
+func Nagios(name string, results []Result) string {
+	status := Overall(results)
+	counts := make(map[Status]int)
+	var perfdata []string
+	for _, result := range results {
+		counts[result.Status]++
+		perfdata = append(perfdata, result.Perfdata()...)
+	}
+
+	var summary []string
+	for _, s := range []Status{StatusCritical, StatusWarning, StatusUnknown, StatusOK} {
+		if counts[s] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s %s - %d monitors: %s", name, status, len(results), strings.Join(summary, ", "))
+	if len(perfdata) > 0 {
+		builder.WriteString(" | " + strings.Join(perfdata, " "))
+	}
+	builder.WriteString("\n")
+	for _, result := range results {
+		//|在Nagios输出中用于分隔性能数据，替换掉
+		line := fmt.Sprintf("[%s] %s: %s", result.Status, result.Monitor, result.Summary)
+		builder.WriteString(strings.Replace(line, "|", "/", -1) + "\n")
+	}
+	return builder.String()
+}
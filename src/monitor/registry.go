@@ -0,0 +1,106 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+)
+
+// entry 注册的监控及其默认调度
+type entry struct {
+	monitor  Monitor
+	schedule func() Schedule
+}
+
+// Registry 监控注册表
+type Registry struct {
+	entries []*entry
+	store   *Store
+}
+
+// NewRegistry 创建监控注册表
+func NewRegistry() *Registry {
+	return &Registry{store: NewStore()}
+}
+
+// Register 注册监控，schedule在每次调度前调用，从当前配置读取默认调度
+func (r *Registry) Register(m Monitor, schedule func() Schedule) {
+	r.entries = append(r.entries, &entry{monitor: m, schedule: schedule})
+}
+
+// Get 按名称获取监控
+func (r *Registry) Get(name string) (Monitor, bool) {
+	for _, e := range r.entries {
+		if e.monitor.Name() == name {
+			return e.monitor, true
+		}
+	}
+	return nil, false
+}
+
+// Monitors 所有注册的监控，按注册顺序
+func (r *Registry) Monitors() []Monitor {
+	monitors := make([]Monitor, 0, len(r.entries))
+	for _, e := range r.entries {
+		monitors = append(monitors, e.monitor)
+	}
+	return monitors
+}
+
+// Store 监控结果存储
+func (r *Registry) Store() *Store {
+	return r.store
+}
+
+// Enabled 监控是否启用，配置中未设置时默认启用
+func (r *Registry) Enabled(name string) bool {
+	return config.GetConfig().IsMonitorEnabled(name)
+}
+
+// Schedule 监控的调度，配置中设置了cron或interval时覆盖默认调度
+func (r *Registry) Schedule(name string) Schedule {
+	var schedule Schedule
+	for _, e := range r.entries {
+		if e.monitor.Name() == name {
+			schedule = e.schedule()
+		}
+	}
+	if override, ok := config.GetConfig().Monitors[name]; ok && override != nil {
+		if override.Cron != "" {
+			schedule = Cron(override.Cron)
+		} else if override.Interval > 0 {
+			schedule = Every(override.Interval)
+		}
+	}
+	return schedule
+}
+
+// Run 执行一次监控并记录结果，监控panic时记录为Unknown
+func (r *Registry) Run(ctx context.Context, m Monitor) (result Result) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("Monitor %s panic: %v", m.Name(), err)
+			result = ErrorResult(m.Name(), StatusUnknown, "监控执行异常", fmt.Errorf("panic: %v", err))
+		}
+		r.record(m.Name(), result)
+	}()
+	start := time.Now()
+	result = m.Run(ctx)
+	log.Debugf("Monitor %s finished in %s, status: %s, summary: %s", m.Name(), time.Since(start), result.Status, result.Summary)
+	return result
+}
+
+// record 记录结果，补全监控名称及时间
+func (r *Registry) record(name string, result Result) {
+	if result.Monitor == "" {
+		result.Monitor = name
+	}
+	if result.Time.IsZero() {
+		result.Time = time.Now()
+	}
+	r.store.Record(result)
+}
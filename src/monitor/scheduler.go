@@ -0,0 +1,179 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+	log "github.com/sirupsen/logrus"
+)
+
+// job 正在调度的监控
+type job struct {
+	schedule Schedule
+	cancel   context.CancelFunc //只停止调度，不中断正在执行的监控
+	cron     *cron.Cron
+}
+
+// Scheduler 按各监控的间隔或cron表达式执行监控
+type Scheduler struct {
+	registry *Registry
+	ctx      context.Context
+	mu       sync.Mutex
+	jobs     map[string]*job
+	stopped  bool //Stop后不再开始新的执行
+	wg       sync.WaitGroup
+}
+
+// NewScheduler 创建调度器
+func NewScheduler(registry *Registry) *Scheduler {
+	return &Scheduler{
+		registry: registry,
+		jobs:     make(map[string]*job),
+	}
+}
+
+// Start 启动所有已启用的监控，ctx取消后停止调度
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = ctx
+	for _, m := range s.registry.Monitors() {
+		if !s.registry.Enabled(m.Name()) {
+			log.Infof("Monitor %s is disabled", m.Name())
+			continue
+		}
+		if err := s.startJob(ctx, m, s.registry.Schedule(m.Name()), false); err != nil {
+			log.Errorf("Start monitor %s err: %s", m.Name(), err)
+		}
+	}
+}
+
+// Reschedule 配置重新加载后调用，只重启开关或调度发生变化的监控，
+// 监控对象及结果存储不变，已有的故障状态和计数得以保留
+func (s *Scheduler) Reschedule() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped || s.ctx == nil || s.ctx.Err() != nil {
+		return
+	}
+	for _, m := range s.registry.Monitors() {
+		name := m.Name()
+		j, running := s.jobs[name]
+		if !s.registry.Enabled(name) {
+			if running {
+				s.stopJob(j)
+				delete(s.jobs, name)
+				log.Infof("Monitor %s is disabled", name)
+			}
+			continue
+		}
+		schedule := s.registry.Schedule(name)
+		if running && j.schedule == schedule {
+			continue
+		}
+		if running {
+			s.stopJob(j)
+			delete(s.jobs, name)
+		}
+		//已在运行的监控按新的调度等待下一次执行，避免重复检查及通知
+		if err := s.startJob(s.ctx, m, schedule, running); err != nil {
+			log.Errorf("Reschedule monitor %s err: %s", name, err)
+		}
+	}
+}
+
+// Stop 停止所有监控，等待正在执行的监控结束，超时返回false
+func (s *Scheduler) Stop(timeout time.Duration) bool {
+	s.mu.Lock()
+	s.stopped = true
+	for name, j := range s.jobs {
+		s.stopJob(j)
+		delete(s.jobs, name)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		log.Warnf("Wait monitors to finish timeout after %s", timeout)
+		return false
+	}
+}
+
+// startJob 按调度启动监控，delay为true时间隔调度的监控等待一个间隔后再首次执行，
+// 监控使用ctx执行，只在退出时取消，重新调度时只停止jobCtx控制的调度，正在执行的监控继续运行到结束
+func (s *Scheduler) startJob(ctx context.Context, m Monitor, schedule Schedule, delay bool) error {
+	jobCtx, cancel := context.WithCancel(ctx)
+	j := &job{schedule: schedule, cancel: cancel}
+
+	if schedule.Cron != "" {
+		j.cron = cron.New()
+		err := j.cron.AddFunc(schedule.Cron, func() {
+			if !s.begin() {
+				return
+			}
+			defer s.wg.Done()
+			if jobCtx.Err() != nil {
+				return
+			}
+			s.registry.Run(ctx, m)
+		})
+		if err != nil {
+			cancel()
+			return err
+		}
+		j.cron.Start()
+		log.Infof("Start monitor %s with cron [%s]", m.Name(), schedule.Cron)
+	} else {
+		interval := schedule.Interval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for {
+				if !delay {
+					s.registry.Run(ctx, m)
+				}
+				delay = false
+				select {
+				case <-jobCtx.Done():
+					return
+				case <-time.After(interval):
+				}
+			}
+		}()
+		log.Infof("Start monitor %s every %s", m.Name(), interval)
+	}
+
+	s.jobs[m.Name()] = j
+	return nil
+}
+
+// begin cron触发时登记一次执行，在锁内检查是否已经Stop，保证Stop开始等待后不会再有新的执行
+func (s *Scheduler) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+// stopJob 停止监控的调度，正在执行的监控不中断
+func (s *Scheduler) stopJob(j *job) {
+	j.cancel()
+	if j.cron != nil {
+		j.cron.Stop()
+	}
+}
@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxIncidents 保留的最近故障数
+const maxIncidents = 100
+
+// Incident 故障记录，监控状态从OK变为非OK时开始，恢复OK时结束
+type Incident struct {
+	Monitor string    `json:"monitor"`
+	Status  Status    `json:"status"`  //故障期间最严重的状态
+	Summary string    `json:"summary"` //故障开始时的描述
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end,omitempty"` //未恢复时为零值
+}
+
+// IsOpen 故障是否还未恢复
+func (i Incident) IsOpen() bool {
+	return i.End.IsZero()
+}
+
+// LastError 监控最近一次检查出错的信息
+type LastError struct {
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// Store 各监控最近一次的结果及故障记录
+type Store struct {
+	mu         sync.RWMutex
+	results    map[string]Result
+	lastErrors map[string]LastError
+	incidents  []*Incident
+	open       map[string]*Incident
+}
+
+// NewStore 创建状态存储
+func NewStore() *Store {
+	return &Store{
+		results:    make(map[string]Result),
+		lastErrors: make(map[string]LastError),
+		open:       make(map[string]*Incident),
+	}
+}
+
+// Record 记录检查结果，更新故障记录
+func (s *Store) Record(result Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[result.Monitor] = result
+	if result.Err != nil {
+		s.lastErrors[result.Monitor] = LastError{Error: result.Err.Error(), Time: result.Time}
+	}
+
+	incident, ok := s.open[result.Monitor]
+	if result.Status == StatusOK {
+		if ok {
+			incident.End = result.Time
+			delete(s.open, result.Monitor)
+		}
+		return
+	}
+	if ok {
+		incident.Status = Worse(incident.Status, result.Status)
+		return
+	}
+	incident = &Incident{
+		Monitor: result.Monitor,
+		Status:  result.Status,
+		Summary: result.Summary,
+		Start:   result.Time,
+	}
+	s.open[result.Monitor] = incident
+	s.incidents = append(s.incidents, incident)
+	if len(s.incidents) > maxIncidents {
+		s.incidents = s.incidents[len(s.incidents)-maxIncidents:]
+	}
+}
+
+// Last 监控最近一次的结果
+func (s *Store) Last(monitor string) (Result, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result, ok := s.results[monitor]
+	return result, ok
+}
+
+// LastError 监控最近一次检查出错的信息，恢复后依然保留
+func (s *Store) LastError(monitor string) (LastError, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lastError, ok := s.lastErrors[monitor]
+	return lastError, ok
+}
+
+// Results 所有监控最近一次的结果，按名称排序
+func (s *Store) Results() []Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := make([]Result, 0, len(s.results))
+	for _, result := range s.results {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Monitor < results[j].Monitor
+	})
+	return results
+}
+
+// Incidents 最近的故障记录，按开始时间倒序
+func (s *Store) Incidents() []Incident {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	incidents := make([]Incident, 0, len(s.incidents))
+	for i := len(s.incidents) - 1; i >= 0; i-- {
+		incidents = append(incidents, *s.incidents[i])
+	}
+	return incidents
+}
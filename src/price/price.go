@@ -0,0 +1,274 @@
+package price
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+	"chia_monitor/src/mojo"
+)
+
+// currencyPlaceholder url和jsonPath中的币种占位符，替换为小写的币种代码
+const currencyPlaceholder = "{currency}"
+
+// maxPriceResponseSize 价格接口返回内容的最大长度
+const maxPriceResponseSize = 1 << 20
+
+// priceClient 请求价格接口的http客户端，超时时间：5秒
+var priceClient = &http.Client{Timeout: 5 * time.Second}
+
+// Provider 价格来源
+type Provider interface {
+	// Price 获取1 XCH对应的法币价格，ctx取消时中断请求
+	Price(ctx context.Context, currency string) (float64, error)
+}
+
+// Quote 报价
+type Quote struct {
+	Currency string    //币种代码
+	Price    float64   //1 XCH对应的法币价格
+	Time     time.Time //获取价格的时间
+	Stale    bool      //价格来源不可用，使用的是过期的缓存价格
+}
+
+// StaticProvider 手动配置的固定价格
+type StaticProvider struct {
+	Prices map[string]float64
+}
+
+// Price 获取手动配置的价格
+func (s StaticProvider) Price(ctx context.Context, currency string) (float64, error) {
+	for name, price := range s.Prices {
+		if strings.EqualFold(name, currency) {
+			return price, nil
+		}
+	}
+	return 0, errors.Errorf("no static price for %s", currency)
+}
+
+// HTTPProvider 从返回json的http接口获取价格
+type HTTPProvider struct {
+	Url      string //价格接口地址，可以包含{currency}
+	JsonPath string //价格在json中的路径，以.分隔，数组使用下标，可以包含{currency}
+}
+
+// Price 请求价格接口并按照JsonPath取出价格，接口返回非2xx状态码时返回错误
+func (h HTTPProvider) Price(ctx context.Context, currency string) (float64, error) {
+	code := strings.ToLower(currency)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(h.Url, currencyPlaceholder, code), nil)
+	if err != nil {
+		return 0, err
+	}
+	response, err := priceClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	resp, err := ioutil.ReadAll(io.LimitReader(response.Body, maxPriceResponseSize))
+	if err != nil {
+		return 0, err
+	}
+	log.Debug(string(resp))
+	//限流或服务端错误时返回的内容不是价格
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return 0, errors.Errorf("price api returned %s", response.Status)
+	}
+
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(resp))
+	decoder.UseNumber()
+	if err = decoder.Decode(&data); err != nil {
+		return 0, errors.Wrap(err, "decode price response")
+	}
+	return lookup(data, strings.ReplaceAll(h.JsonPath, currencyPlaceholder, code))
+}
+
+// lookup 按照路径从json中取出数字
+func lookup(data interface{}, path string) (float64, error) {
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			continue
+		}
+		switch node := data.(type) {
+		case map[string]interface{}:
+			value, ok := node[key]
+			if !ok {
+				return 0, errors.Errorf("key %q of json path %q not found", key, path)
+			}
+			data = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return 0, errors.Errorf("index %q of json path %q out of range", key, path)
+			}
+			data = node[index]
+		default:
+			return 0, errors.Errorf("json path %q not found", path)
+		}
+	}
+
+	switch value := data.(type) {
+	case json.Number:
+		return value.Float64()
+	case string:
+		return strconv.ParseFloat(value, 64)
+	default:
+		return 0, errors.Errorf("value of json path %q is not a number", path)
+	}
+}
+
+// CachedProvider 缓存价格，价格来源不可用时在允许的过期时间内使用缓存价格
+type CachedProvider struct {
+	Provider Provider
+	TTL      time.Duration //缓存有效时间
+	MaxStale time.Duration //价格来源不可用时，缓存价格最长可以使用多久
+
+	mu     sync.Mutex
+	quotes map[string]Quote
+}
+
+// Quote 获取报价，请求价格来源时不持有锁，不阻塞其它币种及缓存读取
+func (c *CachedProvider) Quote(ctx context.Context, currency string) (Quote, error) {
+	c.mu.Lock()
+	cached, ok := c.quotes[currency]
+	c.mu.Unlock()
+	if ok && time.Since(cached.Time) < c.TTL {
+		return cached, nil
+	}
+
+	price, err := c.Provider.Price(ctx, currency)
+	if err != nil {
+		if ok && time.Since(cached.Time) < c.MaxStale {
+			log.Warnf("Get %s price failed, use stale price of %s: %s", currency, cached.Time.Format("2006-01-02 15:04:05"), err)
+			cached.Stale = true
+			return cached, nil
+		}
+		return Quote{}, errors.Wrapf(err, "get %s price", currency)
+	}
+
+	quote := Quote{Currency: currency, Price: price, Time: time.Now()}
+	c.mu.Lock()
+	if c.quotes == nil {
+		c.quotes = make(map[string]Quote)
+	}
+	c.quotes[currency] = quote
+	c.mu.Unlock()
+	return quote, nil
+}
+
+// Price 获取价格，实现Provider接口
+func (c *CachedProvider) Price(ctx context.Context, currency string) (float64, error) {
+	quote, err := c.Quote(ctx, currency)
+	return quote.Price, err
+}
+
+var (
+	defaultProvider *CachedProvider
+	defaultConfig   *config.PriceConfig //创建defaultProvider时的配置
+	defaultLock     sync.Mutex
+)
+
+// getDefaultProvider 根据配置文件创建价格来源，未配置时返回nil，价格配置修改后重新创建
+func getDefaultProvider() *CachedProvider {
+	//获取配置文件
+	cfg := config.GetConfig()
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	if defaultConfig != nil && reflect.DeepEqual(*defaultConfig, *cfg.PriceConfig) {
+		return defaultProvider
+	}
+	priceConfig := *cfg.PriceConfig
+	defaultConfig, defaultProvider = &priceConfig, nil
+
+	var provider Provider
+	switch priceConfig.Provider {
+	case "http":
+		provider = HTTPProvider{Url: priceConfig.Url, JsonPath: priceConfig.JsonPath}
+	case "static":
+		provider = StaticProvider{Prices: priceConfig.Static}
+	case "":
+		return nil
+	default:
+		log.Error("Unknown price provider: ", priceConfig.Provider)
+		return nil
+	}
+	defaultProvider = &CachedProvider{
+		Provider: provider,
+		TTL:      time.Duration(priceConfig.CacheMinutes) * time.Minute,
+		MaxStale: time.Duration(priceConfig.MaxStaleMinutes) * time.Minute,
+	}
+	return defaultProvider
+}
+
+// Values 计算XCH数量在配置的各个法币下的价值，未配置价格来源或获取失败时返回nil
+func Values(ctx context.Context, amount mojo.Amount) map[string]float64 {
+	provider := getDefaultProvider()
+	if provider == nil {
+		return nil
+	}
+	values := make(map[string]float64)
+	for _, currency := range config.GetConfig().PriceConfig.Currencies {
+		quote, err := provider.Quote(ctx, currency)
+		if err != nil {
+			log.Error("Get price err: ", err)
+			continue
+		}
+		values[currency] = amount.XCH() * quote.Price
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// Format 格式化XCH数量的法币价值，如：≈ ¥123.45 / $17.20，价格过期时标注，未配置价格来源时返回空
+func Format(ctx context.Context, amount mojo.Amount) string {
+	provider := getDefaultProvider()
+	if provider == nil {
+		return ""
+	}
+	var values []string
+	var stale bool
+	for _, currency := range config.GetConfig().PriceConfig.Currencies {
+		quote, err := provider.Quote(ctx, currency)
+		if err != nil {
+			log.Error("Get price err: ", err)
+			continue
+		}
+		stale = stale || quote.Stale
+		values = append(values, FormatValue(currency, amount.XCH()*quote.Price))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	result := "≈ " + strings.Join(values, " / ")
+	if stale {
+		result = result + "（价格已过期）"
+	}
+	return result
+}
+
+// FormatValue 格式化法币价值
+func FormatValue(currency string, value float64) string {
+	switch strings.ToUpper(currency) {
+	case "CNY":
+		return fmt.Sprintf("¥%.2f", value)
+	case "USD":
+		return fmt.Sprintf("$%.2f", value)
+	default:
+		return fmt.Sprintf("%.2f %s", value, strings.ToUpper(currency))
+	}
+}
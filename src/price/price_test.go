@@ -0,0 +1,98 @@
+package price
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newPriceServer 本地价格接口，status为返回的状态码，返回内容与coingecko格式相同
+func newPriceServer(t *testing.T, status *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("vs_currencies") != "cny" {
+			t.Errorf("vs_currencies = %q, want cny", r.URL.Query().Get("vs_currencies"))
+		}
+		w.WriteHeader(int(atomic.LoadInt32(status)))
+		//限流时也返回价格格式的内容，校验状态码后不能被当作价格
+		_, _ = w.Write([]byte(`{"chia":{"cny":"123.45"}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPProvider(t *testing.T) {
+	status := int32(http.StatusOK)
+	server := newPriceServer(t, &status)
+	provider := HTTPProvider{Url: server.URL + "/simple/price?ids=chia&vs_currencies={currency}", JsonPath: "chia.{currency}"}
+
+	price, err := provider.Price(context.Background(), "CNY")
+	if err != nil {
+		t.Fatalf("Price err = %v", err)
+	}
+	if price != 123.45 {
+		t.Errorf("Price = %v, want 123.45", price)
+	}
+
+	for _, code := range []int32{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusNotFound} {
+		atomic.StoreInt32(&status, code)
+		if price, err := provider.Price(context.Background(), "CNY"); err == nil {
+			t.Errorf("Price with status %d = %v, want error", code, price)
+		}
+	}
+
+	//ctx取消时中断请求
+	atomic.StoreInt32(&status, http.StatusOK)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := provider.Price(ctx, "CNY"); err == nil {
+		t.Error("Price with canceled ctx err = nil, want error")
+	}
+}
+
+func TestCachedProviderStale(t *testing.T) {
+	status := int32(http.StatusOK)
+	server := newPriceServer(t, &status)
+	provider := &CachedProvider{
+		Provider: HTTPProvider{Url: server.URL + "/simple/price?ids=chia&vs_currencies={currency}", JsonPath: "chia.{currency}"},
+		TTL:      0, //每次都请求价格接口
+		MaxStale: time.Hour,
+	}
+
+	quote, err := provider.Quote(context.Background(), "CNY")
+	if err != nil {
+		t.Fatalf("Quote err = %v", err)
+	}
+	if quote.Price != 123.45 || quote.Stale {
+		t.Errorf("Quote = %+v, want fresh price 123.45", quote)
+	}
+
+	//价格接口不可用时使用过期的缓存价格
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	quote, err = provider.Quote(context.Background(), "CNY")
+	if err != nil {
+		t.Fatalf("Quote with unavailable provider err = %v", err)
+	}
+	if quote.Price != 123.45 || !quote.Stale {
+		t.Errorf("Quote with unavailable provider = %+v, want stale price 123.45", quote)
+	}
+
+	//缓存超过MaxStale后返回错误
+	provider.mu.Lock()
+	cached := provider.quotes["CNY"]
+	cached.Time = time.Now().Add(-2 * time.Hour)
+	provider.quotes["CNY"] = cached
+	provider.mu.Unlock()
+	if quote, err := provider.Quote(context.Background(), "CNY"); err == nil {
+		t.Errorf("Quote with expired cache = %+v, want error", quote)
+	}
+
+	//价格接口恢复后重新获取
+	atomic.StoreInt32(&status, http.StatusOK)
+	quote, err = provider.Quote(context.Background(), "CNY")
+	if err != nil || quote.Stale {
+		t.Errorf("Quote after recovery = %+v, %v, want fresh price", quote, err)
+	}
+}
@@ -0,0 +1,192 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+	"chia_monitor/src/utils"
+)
+
+// 修复操作类型
+const (
+	ActionRestartChia    = "restartChia"    //执行重启命令重启整个chia
+	ActionRestartService = "restartService" //通过守护进程重启单个服务
+	ActionSsh            = "ssh"            //通过SSH在远程主机执行命令
+	ActionScript         = "script"         //执行本地脚本
+	ActionRefreshPlots   = "refreshPlots"   //通过收割机rpc刷新图表
+)
+
+// defaultSshConnectTimeout SSH连接超时时间，单位：秒
+const defaultSshConnectTimeout = 10
+
+// refreshPlotsRpcResult 刷新图表返回
+type refreshPlotsRpcResult struct {
+	Error   string `json:"error"`
+	Success bool   `json:"success"`
+}
+
+// findAction 按名称查找修复操作，restartChia未配置时使用内置操作
+func findAction(remediation *config.Remediation, name string) (config.RemediationAction, bool) {
+	for _, action := range remediation.Actions {
+		if action.Name == name {
+			return action, true
+		}
+	}
+	if name == ActionRestartChia {
+		return config.RemediationAction{Name: ActionRestartChia, Type: ActionRestartChia}, true
+	}
+	return config.RemediationAction{}, false
+}
+
+// expand 替换操作配置中的{变量}
+func expand(action config.RemediationAction, vars map[string]string) config.RemediationAction {
+	if len(vars) == 0 {
+		return action
+	}
+	var oldNew []string
+	for key, value := range vars {
+		oldNew = append(oldNew, "{"+key+"}", value)
+	}
+	replacer := strings.NewReplacer(oldNew...)
+	action.Service = replacer.Replace(action.Service)
+	action.Host = replacer.Replace(action.Host)
+	action.User = replacer.Replace(action.User)
+	action.Command = replacer.Replace(action.Command)
+	args := make([]string, len(action.Args))
+	for i, arg := range action.Args {
+		args[i] = replacer.Replace(arg)
+	}
+	action.Args = args
+	return action
+}
+
+// Run 执行修复操作，返回执行结果描述及错误，未实际执行时错误满足Skipped，ctx取消时终止执行的命令
+func Run(ctx context.Context, action config.RemediationAction, reason string) (string, error) {
+	//获取配置文件
+	remediation := config.GetConfig().Remediation
+	log.Infof("Run remediation action [%s] of type [%s] for [%s]", action.Name, action.Type, reason)
+
+	if action.Type != ActionRestartChia && IsDryRun("run remediation action %+v", action) {
+		return fmt.Sprintf("演练模式，未实际执行修复操作%s", action.Name), ErrDryRun
+	}
+
+	var err error
+	switch action.Type {
+	case ActionRestartChia:
+		err = RestartChia(ctx, reason)
+		return Describe(err), err
+	case ActionRestartService:
+		err = restartService(ctx, remediation, action.Service)
+		if err == nil {
+			return fmt.Sprintf("已通过守护进程重启%s", action.Service), nil
+		}
+	case ActionSsh:
+		err = runSsh(ctx, remediation, action)
+		if err == nil {
+			return fmt.Sprintf("已通过SSH在%s执行%s", action.Host, action.Command), nil
+		}
+	case ActionScript:
+		err = execute(ctx, remediation, "", nil, action.Command, action.Args...)
+		if err == nil {
+			return fmt.Sprintf("已执行脚本%s", action.Command), nil
+		}
+	case ActionRefreshPlots:
+		err = refreshPlots(ctx, remediation)
+		if err == nil {
+			return "已刷新收割机图表", nil
+		}
+	default:
+		err = errors.Errorf("unknown action type: %s", action.Type)
+	}
+	log.Errorf("Run remediation action [%s] failed: %s", action.Name, err)
+	return fmt.Sprintf("修复操作%s执行失败：%s", action.Name, err), err
+}
+
+// restartService 通过守护进程先停止再启动服务
+func restartService(ctx context.Context, remediation *config.Remediation, service string) error {
+	if service == "" {
+		return errors.New("service is empty")
+	}
+	daemon := Daemon{
+		Url:      remediation.DaemonUrl,
+		CertPath: remediation.DaemonCertPath,
+		KeyPath:  remediation.DaemonKeyPath,
+	}
+	//服务已经停止时停止会失败，继续启动
+	if err := daemon.StopService(ctx, service); err != nil {
+		log.Warnf("Stop service %s err: %s", service, err)
+	}
+	return daemon.StartService(ctx, service)
+}
+
+// runSsh 通过SSH在远程主机执行命令，使用BatchMode避免等待输入密码
+func runSsh(ctx context.Context, remediation *config.Remediation, action config.RemediationAction) error {
+	if action.Host == "" || action.Command == "" {
+		return errors.New("ssh host or command is empty")
+	}
+	args := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=" + strconv.Itoa(defaultSshConnectTimeout)}
+	if action.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(action.Port))
+	}
+	if action.IdentityFile != "" {
+		args = append(args, "-i", action.IdentityFile)
+	}
+	target := action.Host
+	if action.User != "" {
+		target = action.User + "@" + action.Host
+	}
+	args = append(args, target, action.Command)
+	args = append(args, action.Args...)
+	return execute(ctx, remediation, "", nil, "ssh", args...)
+}
+
+// refreshPlots 通过收割机rpc刷新图表
+func refreshPlots(ctx context.Context, remediation *config.Remediation) error {
+	url := remediation.HarvesterRpcUrl + "refresh_plots"
+	//发起请求
+	resp, err := utils.PostHttpsContext(ctx, url, struct{}{}, "application/json", remediation.HarvesterCertPath, remediation.HarvesterKeyPath)
+	if err != nil {
+		return err
+	}
+	log.Debug(string(resp))
+
+	var rpcResult refreshPlotsRpcResult
+	if err = json.Unmarshal(resp, &rpcResult); err != nil {
+		return err
+	}
+	if !rpcResult.Success {
+		return errors.Errorf("refresh plots failed: %s", rpcResult.Error)
+	}
+	return nil
+}
+
+// execute 执行命令，超过重启超时时间或ctx取消后终止
+func execute(ctx context.Context, remediation *config.Remediation, dir string, env []string, name string, args ...string) error {
+	timeout := time.Duration(remediation.RestartTimeoutMinutes) * time.Minute
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.Errorf("command timeout after %s", timeout)
+	}
+	if err != nil {
+		log.Errorf("Execute cmd [%s] failed with error:\n%s\n%s", name, err.Error(), string(output))
+		return errors.Wrapf(err, "execute %s", name)
+	}
+	log.Infof("Execute cmd [%s] succeed with output:\n%s", name, string(output))
+	return nil
+}
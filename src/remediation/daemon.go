@@ -0,0 +1,124 @@
+package remediation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// daemonTimeout 守护进程请求超时时间，停止服务需要等待服务退出
+const daemonTimeout = 2 * time.Minute
+
+// Daemon chia守护进程，通过websocket管理各个服务
+type Daemon struct {
+	Url      string
+	CertPath string
+	KeyPath  string
+}
+
+// DaemonRequest 守护进程请求
+type DaemonRequest struct {
+	Command     string      `json:"command"`
+	Ack         bool        `json:"ack"`
+	Data        interface{} `json:"data"`
+	RequestId   string      `json:"request_id"`
+	Destination string      `json:"destination"`
+	Origin      string      `json:"origin"`
+}
+
+// ServiceRequest 启动/停止服务请求
+type ServiceRequest struct {
+	Service string `json:"service"`
+}
+
+// DaemonResponse 守护进程返回，只解析是否成功
+type DaemonResponse struct {
+	Command   string `json:"command"`
+	RequestId string `json:"request_id"`
+	Data      struct {
+		Error   string `json:"error"`
+		Success bool   `json:"success"`
+	} `json:"data"`
+}
+
+// call 发送命令并等待相同request_id的返回，ctx取消时关闭连接中断等待
+func (d Daemon) call(ctx context.Context, command string, data interface{}) (daemonResponse DaemonResponse, err error) {
+	requestId := make([]byte, 32)
+	if _, err = rand.Read(requestId); err != nil {
+		return
+	}
+	daemonRequest := DaemonRequest{
+		Command:     command,
+		Data:        data,
+		RequestId:   hex.EncodeToString(requestId),
+		Destination: "daemon",
+		Origin:      "chia_monitor",
+	}
+	payload, err := json.Marshal(daemonRequest)
+	if err != nil {
+		return
+	}
+
+	conn, err := dialWebsocket(ctx, d.Url, d.CertPath, d.KeyPath, daemonTimeout)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		_ = conn.Close()
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+	if err = conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+		return
+	}
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return daemonResponse, err
+		}
+		log.Debug(string(message))
+		if err = json.Unmarshal(message, &daemonResponse); err != nil {
+			return daemonResponse, err
+		}
+		if daemonResponse.RequestId == daemonRequest.RequestId {
+			return daemonResponse, nil
+		}
+	}
+}
+
+// callService 启动或停止服务
+func (d Daemon) callService(ctx context.Context, command, service string) error {
+	daemonResponse, err := d.call(ctx, command, ServiceRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	if !daemonResponse.Data.Success {
+		return errors.Errorf("%s %s failed: %s", command, service, daemonResponse.Data.Error)
+	}
+	log.Infof("%s %s success", command, service)
+	return nil
+}
+
+// StartService 启动服务，如：chia_full_node、chia_harvester
+func (d Daemon) StartService(ctx context.Context, service string) error {
+	return d.callService(ctx, "start_service", service)
+}
+
+// StopService 停止服务
+func (d Daemon) StopService(ctx context.Context, service string) error {
+	return d.callService(ctx, "stop_service", service)
+}
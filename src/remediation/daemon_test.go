@@ -0,0 +1,71 @@
+package remediation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newDaemonServer 本地守护进程，先推送一条其他服务的消息，再按请求返回结果，success为false时返回错误
+func newDaemonServer(t *testing.T, success bool, delay time.Duration) Daemon {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade err = %v", err)
+			return
+		}
+		defer conn.Close()
+		var daemonRequest DaemonRequest
+		if err := conn.ReadJSON(&daemonRequest); err != nil {
+			t.Errorf("read request err = %v", err)
+			return
+		}
+		time.Sleep(delay)
+		_ = conn.WriteJSON(map[string]interface{}{"command": "state_changed", "request_id": "other"})
+		daemonResponse := DaemonResponse{Command: daemonRequest.Command, RequestId: daemonRequest.RequestId}
+		daemonResponse.Data.Success = success
+		if !success {
+			daemonResponse.Data.Error = "unknown service"
+		}
+		_ = conn.WriteJSON(daemonResponse)
+		//等待客户端关闭连接
+		_, _, _ = conn.ReadMessage()
+	}))
+	t.Cleanup(server.Close)
+	return Daemon{Url: "ws" + strings.TrimPrefix(server.URL, "http")}
+}
+
+func TestDaemonCall(t *testing.T) {
+	daemon := newDaemonServer(t, true, 0)
+	daemonResponse, err := daemon.call(context.Background(), "start_service", ServiceRequest{Service: "chia_harvester"})
+	if err != nil {
+		t.Fatalf("call err = %v", err)
+	}
+	//跳过其他request_id的消息
+	if daemonResponse.Command != "start_service" || !daemonResponse.Data.Success {
+		t.Errorf("call = %+v, want successful start_service", daemonResponse)
+	}
+
+	if err := newDaemonServer(t, false, 0).StopService(context.Background(), "chia_unknown"); err == nil {
+		t.Error("StopService with failed response err = nil, want error")
+	}
+}
+
+func TestDaemonCallCanceled(t *testing.T) {
+	daemon := newDaemonServer(t, true, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := daemon.StartService(ctx, "chia_harvester"); err == nil {
+		t.Error("StartService with canceled ctx err = nil, want error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("StartService returned after %s, want it interrupted by ctx", elapsed)
+	}
+}
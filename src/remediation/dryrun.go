@@ -0,0 +1,27 @@
+package remediation
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+)
+
+// IsDryRun 是否为演练模式，是的话记录将要执行的操作
+func IsDryRun(format string, args ...interface{}) bool {
+	if !config.GetConfig().DryRun.Enabled {
+		return false
+	}
+	log.Warnf("[DRY-RUN] Would %s", fmt.Sprintf(format, args...))
+	return true
+}
+
+// RemoveFile 删除文件，演练模式下只记录日志
+func RemoveFile(path string) error {
+	if IsDryRun("remove file [%s]", path) {
+		return nil
+	}
+	return os.Remove(path)
+}
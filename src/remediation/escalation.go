@@ -0,0 +1,164 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+)
+
+// 监控的故障条件，用于配置修复操作的升级顺序
+const (
+	ConditionBlockchainState  = "blockchainState"  //获取区块链状态错误或失败
+	ConditionSyncStall        = "syncStall"        //区块链同步停滞
+	ConditionLowPeers         = "lowPeers"         //全节点连接数不足
+	ConditionHarvesterOffline = "harvesterOffline" //收割机掉线，变量：harvester
+)
+
+// defaultEscalations 未配置时各故障条件的修复操作
+var defaultEscalations = map[string][]string{
+	ConditionBlockchainState: {ActionRestartChia},
+	ConditionSyncStall:       {ActionRestartChia},
+	ConditionLowPeers:        {ActionRestartChia},
+}
+
+var (
+	// ErrNoAction 故障条件没有配置修复操作
+	ErrNoAction = errors.New("no remediation action")
+	// ErrActionBackoff 距离上次修复操作时间太短
+	ErrActionBackoff = errors.New("remediation action is in backoff")
+)
+
+// escalationState 某个故障的修复进度
+type escalationState struct {
+	step    int       //已执行的修复操作数
+	lastRun time.Time //上次执行修复操作的时间
+}
+
+// Escalator 按配置顺序逐步升级修复操作：每次故障执行下一个操作，全部执行后重复最后一个，操作之间指数退避
+type Escalator struct {
+	mu     sync.Mutex
+	states map[string]map[string]*escalationState //故障条件 -> 变量 -> 修复进度
+}
+
+var defaultEscalator = &Escalator{}
+
+// Escalate 使用默认的升级器执行故障条件的下一个修复操作
+func Escalate(ctx context.Context, condition string, vars map[string]string) (string, error) {
+	return defaultEscalator.Escalate(ctx, condition, vars)
+}
+
+// Reset 使用默认的升级器重置故障条件的修复进度
+func Reset(condition string) {
+	defaultEscalator.Reset(condition)
+}
+
+// HasActions 故障条件是否配置了修复操作
+func HasActions(condition string) bool {
+	return len(escalation(config.GetConfig().Remediation, condition)) > 0
+}
+
+// escalation 故障条件的修复操作，配置中没有时使用默认值
+func escalation(remediation *config.Remediation, condition string) []string {
+	if actions, ok := remediation.Escalations[condition]; ok {
+		return actions
+	}
+	return defaultEscalations[condition]
+}
+
+// varsKey 变量排序后拼接，区分同一故障条件的不同对象
+func varsKey(vars map[string]string) string {
+	var pairs []string
+	for key, value := range vars {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Skipped 修复操作是否因为没有配置、退避、熔断或演练模式而没有执行
+func Skipped(err error) bool {
+	switch errors.Cause(err) {
+	case ErrNoAction, ErrActionBackoff, ErrRestartInProgress, ErrBackoff, ErrCircuitOpen, ErrDryRun:
+		return true
+	}
+	return false
+}
+
+// Escalate 执行故障条件的下一个修复操作，返回执行结果描述及错误，没有配置修复操作时返回ErrNoAction，ctx取消时终止执行的命令
+func (e *Escalator) Escalate(ctx context.Context, condition string, vars map[string]string) (string, error) {
+	//获取配置文件
+	remediation := config.GetConfig().Remediation
+	actions := escalation(remediation, condition)
+	if len(actions) == 0 {
+		return "", ErrNoAction
+	}
+
+	e.mu.Lock()
+	if e.states == nil {
+		e.states = make(map[string]map[string]*escalationState)
+	}
+	if e.states[condition] == nil {
+		e.states[condition] = make(map[string]*escalationState)
+	}
+	key := varsKey(vars)
+	state, ok := e.states[condition][key]
+	if !ok {
+		state = &escalationState{}
+		e.states[condition][key] = state
+	}
+	now := time.Now()
+	if delay := backoff(remediation, state.step); !state.lastRun.IsZero() && now.Sub(state.lastRun) < delay {
+		e.mu.Unlock()
+		log.Warnf("Remediation of [%s] skipped, last run: %s, backoff: %s", condition, state.lastRun.Format("2006-01-02 15:04:05"), delay)
+		return "距离上次修复操作时间太短，暂不执行", ErrActionBackoff
+	}
+	index := state.step
+	if index >= len(actions) {
+		index = len(actions) - 1
+	}
+	//先占用这一步，防止并发的故障重复执行同一个操作，未实际执行时再回退
+	previous := *state
+	state.step = state.step + 1
+	state.lastRun = now
+	e.mu.Unlock()
+
+	name := actions[index]
+	action, ok := findAction(remediation, name)
+	if !ok {
+		log.Errorf("Remediation action [%s] of [%s] not found", name, condition)
+		return fmt.Sprintf("修复操作%s不存在", name), errors.Errorf("remediation action %s not found", name)
+	}
+	reason := condition
+	if key != "" {
+		reason = reason + " " + key
+	}
+	message, err := Run(ctx, expand(action, vars), reason)
+	if Skipped(err) {
+		e.rollback(state, previous, now)
+	}
+	return message, err
+}
+
+// rollback 修复操作被跳过或为演练模式时恢复修复进度，避免影响之后实际执行的操作，期间已有新的操作时不回退
+func (e *Escalator) rollback(state *escalationState, previous escalationState, reserved time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if state.lastRun.Equal(reserved) {
+		*state = previous
+	}
+}
+
+// Reset 故障恢复后重置修复进度，下次故障从第一个修复操作开始
+func (e *Escalator) Reset(condition string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.states, condition)
+}
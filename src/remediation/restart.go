@@ -0,0 +1,163 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"chia_monitor/src/config"
+	"chia_monitor/src/wechat"
+)
+
+var (
+	// ErrRestartInProgress 已有重启正在执行
+	ErrRestartInProgress = errors.New("restart is in progress")
+	// ErrBackoff 距离上次重启时间太短
+	ErrBackoff = errors.New("restart is in backoff")
+	// ErrCircuitOpen 时间窗口内重启次数已达上限
+	ErrCircuitOpen = errors.New("too many restarts, circuit breaker is open")
+	// ErrDryRun 演练模式，未实际执行
+	ErrDryRun = errors.New("dry run, not executed")
+)
+
+// Restarter 重启chia：重启之间指数退避，时间窗口内重启次数超过上限时熔断并发送严重通知，同一时间只执行一个重启
+type Restarter struct {
+	mu          sync.Mutex
+	running     bool
+	lastRestart time.Time
+	consecutive int         //未恢复的连续重启次数，用于计算退避时间
+	history     []time.Time //时间窗口内的重启时间
+	isEscalated bool        //熔断后是否已经发送严重通知
+}
+
+var defaultRestarter = &Restarter{}
+
+// RestartChia 使用默认的重启器重启chia
+func RestartChia(ctx context.Context, reason string) error {
+	return defaultRestarter.Restart(ctx, reason)
+}
+
+// RecordRecovery 使用默认的重启器记录chia已恢复
+func RecordRecovery() {
+	defaultRestarter.RecordRecovery()
+}
+
+// Describe 重启结果描述，用于通知
+func Describe(err error) string {
+	//获取配置文件
+	coinName := config.GetConfig().Coin.Name
+	switch errors.Cause(err) {
+	case nil:
+		return fmt.Sprintf("已自动重启%s", coinName)
+	case ErrRestartInProgress:
+		return fmt.Sprintf("%s正在重启中", coinName)
+	case ErrBackoff:
+		return fmt.Sprintf("距离上次重启时间太短，暂不重启%s", coinName)
+	case ErrCircuitOpen:
+		return fmt.Sprintf("重启次数过多，已停止自动重启%s，请人工处理", coinName)
+	case ErrDryRun:
+		return fmt.Sprintf("演练模式，未实际重启%s", coinName)
+	default:
+		return fmt.Sprintf("重启%s失败：%s", coinName, err)
+	}
+}
+
+// backoff 第n次连续重启前需要等待的时间
+func backoff(remediation *config.Remediation, n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	delay := time.Duration(remediation.BackoffBaseMinutes) * time.Minute
+	maxDelay := time.Duration(remediation.BackoffMaxMinutes) * time.Minute
+	for i := 1; i < n && delay < maxDelay; i++ {
+		delay = delay * 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// Restart 执行重启命令，ctx取消时终止重启命令
+func (r *Restarter) Restart(ctx context.Context, reason string) error {
+	//获取配置文件
+	cfg := config.GetConfig()
+	remediation := cfg.Remediation
+	now := time.Now()
+
+	//演练模式不记录重启，不影响退避和熔断
+	if IsDryRun("execute restart command [%s %v] in [%s] for [%s]", remediation.RestartCommand, remediation.RestartArgs, remediation.RestartDir, reason) {
+		return ErrDryRun
+	}
+
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		log.Warnf("Restart chia for [%s] skipped: %s", reason, ErrRestartInProgress)
+		return ErrRestartInProgress
+	}
+
+	//清理时间窗口之外的重启记录
+	window := time.Duration(remediation.RestartWindowMinutes) * time.Minute
+	var history []time.Time
+	for _, restartTime := range r.history {
+		if now.Sub(restartTime) < window {
+			history = append(history, restartTime)
+		}
+	}
+	r.history = history
+	if len(r.history) >= remediation.MaxRestarts {
+		isEscalated := r.isEscalated
+		r.isEscalated = true
+		r.mu.Unlock()
+		log.Errorf("Restart chia for [%s] skipped: %s", reason, ErrCircuitOpen)
+		if !isEscalated {
+			detail := fmt.Sprintf("%d分钟内已重启%s %d次，仍然没有恢复，已停止自动重启，最近一次原因：%s",
+				remediation.RestartWindowMinutes, cfg.Coin.Name, len(r.history), reason)
+			wechat.SendCriticalNoticeToWechat(cfg.Monitor.MachineName, "自动重启", detail, "请尽快登陆设备人工处理")
+		}
+		return ErrCircuitOpen
+	}
+
+	if delay := backoff(remediation, r.consecutive); !r.lastRestart.IsZero() && now.Sub(r.lastRestart) < delay {
+		r.mu.Unlock()
+		log.Warnf("Restart chia for [%s] skipped: %s, last restart: %s, backoff: %s",
+			reason, ErrBackoff, r.lastRestart.Format("2006-01-02 15:04:05"), delay)
+		return ErrBackoff
+	}
+
+	r.running = true
+	r.lastRestart = now
+	r.consecutive = r.consecutive + 1
+	r.history = append(r.history, now)
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+	}()
+
+	log.Infof("Restart chia for [%s], consecutive restart count: %d", reason, r.consecutive)
+	return runCommand(ctx, remediation)
+}
+
+// RecordRecovery 记录chia已恢复，重置退避时间和熔断通知
+func (r *Restarter) RecordRecovery() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.consecutive > 0 {
+		log.Info("Chia recovered, reset restart backoff")
+	}
+	r.consecutive = 0
+	r.isEscalated = false
+}
+
+// runCommand 执行配置的重启命令
+func runCommand(ctx context.Context, remediation *config.Remediation) error {
+	return execute(ctx, remediation, remediation.RestartDir, remediation.RestartEnv, remediation.RestartCommand, remediation.RestartArgs...)
+}
@@ -0,0 +1,46 @@
+package remediation
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsMaxMessageSize 单条消息的最大长度
+const wsMaxMessageSize = 16 << 20
+
+// dialWebsocket 使用客户端证书连接websocket服务，timeout为整个连接的读写超时时间，ctx取消时中断握手
+func dialWebsocket(ctx context.Context, rawUrl, certFile, keyFile string, timeout time.Duration) (*websocket.Conn, error) {
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: timeout,
+	}
+	//ws连接不需要证书
+	if certFile != "" || keyFile != "" {
+		cliCrt, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		dialer.TLSClientConfig = &tls.Config{
+			Certificates:       []tls.Certificate{cliCrt},
+			InsecureSkipVerify: true,
+		}
+	}
+	conn, resp, err := dialer.DialContext(ctx, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = conn.SetReadDeadline(deadline)
+	_ = conn.SetWriteDeadline(deadline)
+	conn.SetReadLimit(wsMaxMessageSize)
+	return conn, nil
+}
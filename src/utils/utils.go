@@ -2,16 +2,26 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 )
 
-// PostHttps 使用证书发起Https Post请求
+// rpcTimeout Https rpc请求超时时间
+const rpcTimeout = 30 * time.Second
+
+// PostHttps 使用证书发起Https Post请求，超时时间：30秒
 func PostHttps(url string, data interface{}, contentType, certFile, keyFile string) ([]byte, error) {
+	return PostHttpsContext(context.Background(), url, data, contentType, certFile, keyFile)
+}
+
+// PostHttpsContext 使用证书发起Https Post请求，超时时间：30秒，ctx取消时中断请求
+func PostHttpsContext(ctx context.Context, url string, data interface{}, contentType, certFile, keyFile string) ([]byte, error) {
 	// 创建证书池及各类对象
 	var client *http.Client
 	var resp *http.Response
@@ -27,6 +37,7 @@ func PostHttps(url string, data interface{}, contentType, certFile, keyFile stri
 
 	// 把上面的准备内容传入 client
 	client = &http.Client{
+		Timeout: rpcTimeout,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{
 				Certificates:       []tls.Certificate{cliCrt},
@@ -35,8 +46,13 @@ func PostHttps(url string, data interface{}, contentType, certFile, keyFile stri
 		},
 	}
 
-	// Get 请求
-	resp, err = client.Post(url, contentType, bytes.NewBuffer(jsonStr))
+	// Post 请求
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err = client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -56,10 +72,20 @@ func PostHttps(url string, data interface{}, contentType, certFile, keyFile stri
 // contentType： 请求体格式，如：application/json
 // content：     请求放回的内容
 func Post(url string, data interface{}, contentType string) (result []byte, err error) {
+	return PostContext(context.Background(), url, data, contentType)
+}
+
+// PostContext 发送POST请求，ctx取消时中断请求
+func PostContext(ctx context.Context, url string, data interface{}, contentType string) (result []byte, err error) {
 	// 超时时间：5秒
 	client := &http.Client{Timeout: 5 * time.Second}
 	jsonStr, _ := json.Marshal(data)
-	resp, err := client.Post(url, contentType, bytes.NewBuffer(jsonStr))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
 	if err != nil {
 		return result, err
 	}
@@ -72,9 +98,18 @@ func Post(url string, data interface{}, contentType string) (result []byte, err
 // Get 发送Get请求
 // url：请求地址
 func Get(url string) (result []byte, err error) {
+	return GetContext(context.Background(), url)
+}
+
+// GetContext 发送Get请求，ctx取消时中断请求
+func GetContext(ctx context.Context, url string) (result []byte, err error) {
 	// 超时时间：5秒
 	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return result, err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return result, err
 	}
@@ -95,3 +130,28 @@ func Exists(path string) bool {
 	}
 	return true
 }
+
+// ReadJSONFile 读取json文件并反序列化
+func ReadJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteJSONFile 序列化为json并写入文件，先写临时文件再重命名，防止写入中断导致文件损坏
+func WriteJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -2,15 +2,29 @@ package wechat
 
 import (
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
+	"chia_monitor/src/config"
 	"chia_monitor/src/utils"
 )
 
-const chiaMonitorPostUrl = "https://test.wechat.yasin.store/api/v1/online/send_chia_monitor_message"
-const myWechatAccount = "oOsegjnJh_Org9KilAs4CQ7pDjjE"
+const criticalPrefix = "【严重】"
+const dryRunPrefix = "【DRY-RUN】"
+
+// outboxSize 发件箱大小
+const outboxSize = 100
+
+var (
+	outbox     = make(chan ChiaMonitorMessage, outboxSize)
+	outboxOnce sync.Once
+	pending    sync.WaitGroup //未发送完成的消息数
+	outboxMu   sync.Mutex     //保护closed，保证Flush开始后不再调用pending.Add
+	closed     bool           //Flush开始后关闭发件箱，之后的消息直接发送
+)
 
 // ChiaMonitorMessage Chia监控消息结构体
 type ChiaMonitorMessage struct {
@@ -22,24 +36,122 @@ type ChiaMonitorMessage struct {
 	WechatAccount string `json:"wechat_account"`
 }
 
-// SendChiaMonitorNoticeToWechat 发送Chia监控消息给微信
+// SendChiaMonitorNoticeToWechat 发送Chia监控消息给微信，消息放入发件箱异步发送，退出前调用Flush
 func SendChiaMonitorNoticeToWechat(machineName, event, detail, remark string) {
+	chiaMonitorMessage, ok := newMessage(machineName, event, detail, remark)
+	if !ok {
+		return
+	}
+	log.Infof("chiaMonitorMessage: %+v", chiaMonitorMessage)
+	enqueue(chiaMonitorMessage)
+}
+
+// SendTestNotice 同步发送测试通知，返回发送结果，用于检查通知配置
+func SendTestNotice(machineName, event, detail, remark string) error {
+	chiaMonitorMessage, ok := newMessage(machineName, event, detail, remark)
+	if !ok {
+		return nil
+	}
+	log.Infof("chiaMonitorMessage: %+v", chiaMonitorMessage)
+	return send(chiaMonitorMessage)
+}
+
+// newMessage 创建消息，演练模式下添加演练标识，配置了不发送通知时只记录日志并返回false
+func newMessage(machineName, event, detail, remark string) (ChiaMonitorMessage, bool) {
+	cfg := config.GetConfig()
 	chiaMonitorMessage := ChiaMonitorMessage{
 		MachineName:   machineName,
 		Event:         event,
 		Detail:        detail,
 		UpdateTime:    time.Now().Format("2006-01-02 15:04:05"),
 		Remark:        remark,
-		WechatAccount: myWechatAccount,
+		WechatAccount: cfg.Wechat.Account,
 	}
-	log.Infof("chiaMonitorMessage: %+v", chiaMonitorMessage)
-	resp, err := utils.Post(chiaMonitorPostUrl, chiaMonitorMessage, "application/json")
+	if dryRun := cfg.DryRun; dryRun.Enabled {
+		chiaMonitorMessage.Event = dryRunPrefix + chiaMonitorMessage.Event
+		if dryRun.SuppressNotice {
+			log.Infof("[DRY-RUN] Would send chiaMonitorMessage: %+v", chiaMonitorMessage)
+			return chiaMonitorMessage, false
+		}
+	}
+	return chiaMonitorMessage, true
+}
+
+// enqueue 放入发件箱由后台发送，发件箱已满或已关闭时直接发送
+func enqueue(chiaMonitorMessage ChiaMonitorMessage) {
+	if !tryEnqueue(chiaMonitorMessage) {
+		log.Warn("Wechat outbox is full or closed, send notice directly")
+		_ = send(chiaMonitorMessage)
+	}
+}
+
+// tryEnqueue 在锁内放入发件箱，防止与Flush关闭发件箱并发，发件箱已满或已关闭时返回false
+func tryEnqueue(chiaMonitorMessage ChiaMonitorMessage) bool {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	if closed {
+		return false
+	}
+	outboxOnce.Do(func() {
+		go sendOutbox()
+	})
+	pending.Add(1)
+	select {
+	case outbox <- chiaMonitorMessage:
+		return true
+	default:
+		pending.Done()
+		return false
+	}
+}
+
+// sendOutbox 依次发送发件箱中的消息
+func sendOutbox() {
+	for chiaMonitorMessage := range outbox {
+		_ = send(chiaMonitorMessage)
+		pending.Done()
+	}
+}
+
+// Flush 关闭发件箱并等待其中的消息发送完成，超时返回false，之后的消息直接发送
+func Flush(timeout time.Duration) bool {
+	outboxMu.Lock()
+	if !closed {
+		closed = true
+		close(outbox)
+	}
+	outboxMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		log.Warnf("Flush wechat outbox timeout after %s", timeout)
+		return false
+	}
+}
+
+// send 发送消息
+func send(chiaMonitorMessage ChiaMonitorMessage) error {
+	resp, err := utils.Post(config.GetConfig().Wechat.PostUrl, chiaMonitorMessage, "application/json")
 	result := strings.Trim(string(resp), "\"")
 	if err != nil {
 		log.Errorf("Send chia monitor notice failed: %+v", err)
-	} else if result != "success" {
+		return err
+	}
+	if result != "success" {
 		log.Errorf("Send chia monitor notice failed: %s", result)
-	} else {
-		log.Info("Send chia monitor notice success")
+		return errors.Errorf("send chia monitor notice failed: %s", result)
 	}
+	log.Info("Send chia monitor notice success")
+	return nil
+}
+
+// SendCriticalNoticeToWechat 发送严重级别的Chia监控消息给微信，事件名称前添加严重标识
+func SendCriticalNoticeToWechat(machineName, event, detail, remark string) {
+	SendChiaMonitorNoticeToWechat(machineName, criticalPrefix+event, detail, remark)
 }
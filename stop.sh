@@ -1,2 +1,46 @@
 #!/bin/bash
-ps -ef | grep chia_monitor | grep -v grep | awk '{print $2}' | xargs kill -9
+#发送SIGTERM，等待监控正常退出
+#切换到脚本所在目录，与start.sh一致，配置文件中的相对路径以该目录为准
+cd "$(dirname "$0")" || exit 1
+
+#从配置文件读取pid文件路径，未配置时使用默认值
+pidFile=$(grep -E '^pidFile:' ./config.yaml 2>/dev/null | sed -E 's/^pidFile:[[:space:]]*//; s/[[:space:]]+#.*$//; s/^["'\'']//; s/["'\'']$//')
+if [ -z "$pidFile" ]; then
+  pidFile=./chia_monitor.pid
+fi
+
+#pid文件中的进程存在并且是chia_monitor时才停止，防止误杀pid被复用的其它进程
+pid=""
+if [ -f "$pidFile" ]; then
+  pid=$(cat "$pidFile")
+  if ! kill -0 "$pid" 2>/dev/null || [ "$(ps -p "$pid" -o comm= 2>/dev/null)" != "chia_monitor" ]; then
+    echo "pid文件中的进程 $pid 不存在或不是chia_monitor，删除过期的pid文件"
+    rm -f "$pidFile"
+    pid=""
+  fi
+fi
+if [ -z "$pid" ]; then
+  pid=$(pgrep -x chia_monitor)
+fi
+if [ -z "$pid" ]; then
+  echo "chia_monitor未运行"
+  exit 0
+fi
+
+kill -TERM $pid
+#等待进程退出，最多等待60秒
+for i in $(seq 1 60); do
+  running=""
+  for p in $pid; do
+    if kill -0 "$p" 2>/dev/null; then
+      running="$running $p"
+    fi
+  done
+  if [ -z "$running" ]; then
+    echo "chia_monitor已停止"
+    exit 0
+  fi
+  sleep 1
+done
+echo "等待chia_monitor退出超时，进程：$running"
+exit 1